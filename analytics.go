@@ -2,27 +2,127 @@ package bulkCache
 
 import (
 	"sync/atomic"
+	"time"
+)
+
+//operations of latency
+const (
+	LatencyAdd     = "add"
+	LatencyGet     = "get"
+	LatencyGetItem = "get_item"
 )
 
 type (
+	// Analytics counts operations of a bulk or a container,
+	// changes of a bulk are added to the container it is attached to
 	Analytics struct {
-		Queries  int64
-		Memories int64
+		Queries     int64
+		Memories    int64 //bytes of alive values
+		Keys        int64 //bytes of alive sub keys
+		Evictions   int64
+		Expirations int64
+		Adds        int64
+		Hits        int64       //gets finding alive items
+		Misses      int64       //gets finding nothing
+		Access      int64       //unix nano of last add or get
+		Latency     *LatencyVec //latency by operation, nil records nothing
+		parent      atomic.Value
 	}
 )
 
 func NewAnalytics() *Analytics {
-	return &Analytics{}
+	return &Analytics{Latency: NewLatencyVec(DefaultLatencyBuckets)}
+}
+
+// Observe records latency of operation
+func (a *Analytics) Observe(op string, d time.Duration) {
+	if a.Latency != nil {
+		a.Latency.Observe(op, d)
+	}
+}
+
+// up returns analytics of the container, nil if not attached
+func (a *Analytics) up() *Analytics {
+	p, _ := a.parent.Load().(*Analytics)
+	return p
+}
+
+// attach adds bytes of a to parent and every change after
+func (a *Analytics) attach(parent *Analytics) {
+	a.parent.Store(parent)
+	atomic.AddInt64(&parent.Memories, atomic.LoadInt64(&a.Memories))
+	atomic.AddInt64(&parent.Keys, atomic.LoadInt64(&a.Keys))
+}
+
+// detach takes bytes of a back from parent
+func (a *Analytics) detach() {
+	p := a.up()
+	if p == nil {
+		return
+	}
+	a.parent.Store((*Analytics)(nil))
+	atomic.AddInt64(&p.Memories, -atomic.LoadInt64(&a.Memories))
+	atomic.AddInt64(&p.Keys, -atomic.LoadInt64(&a.Keys))
 }
 
-func (a *Analytics) Add(data []byte) {
-	atomic.AddInt64(&a.Memories, int64(len(data)))
+// store accounts bytes of an item without counting an add
+func (a *Analytics) store(key string, data []byte) {
+	for ; a != nil; a = a.up() {
+		atomic.AddInt64(&a.Memories, int64(len(data)))
+		atomic.AddInt64(&a.Keys, int64(len(key)))
+	}
+}
+
+func (a *Analytics) release(key string, data []byte) {
+	for ; a != nil; a = a.up() {
+		atomic.AddInt64(&a.Memories, -int64(len(data)))
+		atomic.AddInt64(&a.Keys, -int64(len(key)))
+	}
+}
+
+// Bytes returns bytes of alive sub keys and values
+func (a *Analytics) Bytes() int64 {
+	return atomic.LoadInt64(&a.Memories) + atomic.LoadInt64(&a.Keys)
+}
+
+func (a *Analytics) Add(key string, data []byte) {
+	n := time.Now().UnixNano()
+	for ; a != nil; a = a.up() {
+		atomic.AddInt64(&a.Adds, 1)
+		atomic.AddInt64(&a.Memories, int64(len(data)))
+		atomic.AddInt64(&a.Keys, int64(len(key)))
+		atomic.StoreInt64(&a.Access, n)
+	}
 }
 
 func (a *Analytics) Get() {
 	atomic.AddInt64(&a.Queries, 1)
+	atomic.StoreInt64(&a.Access, time.Now().UnixNano())
+}
+
+func (a *Analytics) Hit() {
+	atomic.AddInt64(&a.Hits, 1)
 }
 
-func (a *Analytics) Expired(data []byte) {
-	atomic.AddInt64(&a.Memories, -int64(len(data)))
+func (a *Analytics) Miss() {
+	atomic.AddInt64(&a.Misses, 1)
+}
+
+// Removed releases an item deleted or overwritten
+func (a *Analytics) Removed(key string, data []byte) {
+	a.release(key, data)
+}
+
+func (a *Analytics) Expired(key string, data []byte) {
+	for p := a; p != nil; p = p.up() {
+		atomic.AddInt64(&p.Expirations, 1)
+	}
+	a.release(key, data)
+}
+
+func (a *Analytics) Evict(key string, data []byte) {
+	for p := a; p != nil; p = p.up() {
+		atomic.AddInt64(&p.Evictions, 1)
+	}
+	a.release(key, data)
 }
@@ -0,0 +1,61 @@
+package bulkCache
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Analytics(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine, WheelEngine, ShardedEngine} {
+		c, _ := NewContainer("Analytics", engine)
+		c.Add("Video", "a", []byte("12345"), time.Minute)
+		c.Add("Video", "b", []byte("12345"), time.Minute)
+		c.Add("Video", "a", []byte("123"), time.Minute)
+		b, _ := c.GetBulk("Video")
+		if b.Analytics().Memories != 8 || b.Bytes() != 8+2*KeySize {
+			t.Errorf("[%s] overwrite is not released, memories %d bytes %d", engine, b.Analytics().Memories, b.Bytes())
+		}
+		c.DeleteItem("Video", "b")
+		if b.Analytics().Memories != 3 || c.Analytics.Memories != 3 {
+			t.Errorf("[%s] delete is not released, memories %d", engine, b.Analytics().Memories)
+		}
+
+		c.Add("Video", "c", []byte("1234"), time.Millisecond)
+		time.Sleep(time.Millisecond * 5)
+		b.GetAlive()
+		if b.Analytics().Expirations != 1 || c.Analytics.Expirations != 1 || c.Analytics.Memories != 3 {
+			t.Errorf("[%s] expiration is not counted, expirations %d memories %d", engine, c.Analytics.Expirations, c.Analytics.Memories)
+		}
+
+		b.Evict(EvictRandom)
+		if b.Bytes() != 0 || c.Analytics.Evictions != 1 || c.Analytics.Bytes() != 0 {
+			t.Errorf("[%s] eviction is not released, bytes %d", engine, c.Analytics.Bytes())
+		}
+		if c.Analytics.Adds != 4 {
+			t.Errorf("[%s] adds %d", engine, c.Analytics.Adds)
+		}
+
+		c.Add("Audio", "a", []byte("12345"), time.Minute)
+		c.Remove("Audio")
+		if c.Analytics.Bytes() != 0 {
+			t.Errorf("[%s] removed bulk is not released, bytes %d", engine, c.Analytics.Bytes())
+		}
+	}
+}
+
+func Test_AnalyticsMissingBulk(t *testing.T) {
+	c, _ := NewContainer("Analytics Missing", HashEngine)
+	c.Get("Video")
+	c.GetItem("Video", "a")
+	c.Range("Video", time.Now(), time.Now().Add(time.Minute), 0)
+	c.Scan("Video", ScanStart, 10)
+	if c.Analytics.Queries != 0 {
+		t.Errorf("queries of missing bulk are counted, got %d", c.Analytics.Queries)
+	}
+	c.Add("Video", "a", []byte("value"), time.Minute)
+	c.Get("Video")
+	c.GetItem("Video", "a")
+	if c.Analytics.Queries != 2 || c.Analytics.Hits != 2 {
+		t.Errorf("queries %d hits %d", c.Analytics.Queries, c.Analytics.Hits)
+	}
+}
@@ -0,0 +1,445 @@
+package bulkCache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	SyncAlways SyncPolicy = iota
+	SyncEverySecond
+	SyncNever
+)
+
+const (
+	aofAdd byte = iota + 1
+	aofRemove
+	aofFlush
+	aofDelete
+	aofConfig
+
+	aofHeaderSize = 8
+	aofMaxRecord  = 1 << 30
+)
+
+var (
+	ErrCorruptRecord  = errors.New("Append log record is corrupt")
+	ErrRewriteRunning = errors.New("Append log rewrite is running")
+
+	//a record cut by a crash at the end of the log
+	errTornRecord = errors.New("Append log record is torn")
+)
+
+type (
+	SyncPolicy int
+
+	// AppendLog records every write of a container,
+	// a record is [length uint32][crc32 uint32][payload]
+	AppendLog struct {
+		Mut    *sync.Mutex
+		Log    *log.Entry
+		path   string
+		file   *os.File
+		policy SyncPolicy
+		dirty  bool
+		stop   chan struct{}
+		//records written while Rewrite copies items, nil if no rewrite runs
+		rewrite *bytes.Buffer
+	}
+
+	aofRecord struct {
+		Op     byte
+		Bulk   string
+		Sub    string
+		Data   []byte
+		Expire time.Time
+		Config *BulkConfig //of aofConfig
+	}
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "everysec":
+		return SyncEverySecond, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return SyncNever, fmt.Errorf("Unknown sync policy %s, always, everysec or never", s)
+}
+
+func NewAppendLog(path string, policy SyncPolicy) (*AppendLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &AppendLog{
+		Mut:    &sync.Mutex{},
+		path:   path,
+		file:   f,
+		policy: policy,
+		stop:   make(chan struct{}),
+		Log: log.WithFields(log.Fields{
+			"Append Log": path,
+		}),
+	}
+	if policy == SyncEverySecond {
+		go l.syncer()
+	}
+	return l, nil
+}
+
+func (l *AppendLog) syncer() {
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(time.Second):
+		}
+		l.Mut.Lock()
+		if l.dirty {
+			if err := l.file.Sync(); err != nil {
+				l.Log.Error(fmt.Sprintf("Sync append log error[%s]", err.Error()))
+			}
+			l.dirty = false
+		}
+		l.Mut.Unlock()
+	}
+}
+
+func (l *AppendLog) Add(bulk, sub string, value []byte, expire time.Time) error {
+	return l.append(&aofRecord{Op: aofAdd, Bulk: bulk, Sub: sub, Data: value, Expire: expire})
+}
+
+func (l *AppendLog) Delete(bulk, sub string) error {
+	return l.append(&aofRecord{Op: aofDelete, Bulk: bulk, Sub: sub})
+}
+
+func (l *AppendLog) Remove(bulk string) error {
+	return l.append(&aofRecord{Op: aofRemove, Bulk: bulk})
+}
+
+// Config records cfg set to bulk by SetBulkConfig
+func (l *AppendLog) Config(bulk string, cfg *BulkConfig) error {
+	return l.append(&aofRecord{Op: aofConfig, Bulk: bulk, Config: cfg})
+}
+
+func (l *AppendLog) Flush() error {
+	return l.append(&aofRecord{Op: aofFlush})
+}
+
+func (l *AppendLog) append(r *aofRecord) error {
+	l.Mut.Lock()
+	defer l.Mut.Unlock()
+	b := r.encode()
+	if l.rewrite != nil {
+		l.rewrite.Write(b)
+	}
+	if _, err := l.file.Write(b); err != nil {
+		return err
+	}
+	switch l.policy {
+	case SyncAlways:
+		return l.file.Sync()
+	case SyncEverySecond:
+		l.dirty = true
+	}
+	return nil
+}
+
+// Rewrite compacts the log to one config record per bulk config set to c and
+// one add record per alive item of c. Writes go on
+// while items are copied, they are kept aside and appended to the new log
+// before it replaces the old one, so replaying them again is harmless
+func (l *AppendLog) Rewrite(c *Container) error {
+	l.Mut.Lock()
+	if l.rewrite != nil {
+		l.Mut.Unlock()
+		return ErrRewriteRunning
+	}
+	l.rewrite = &bytes.Buffer{}
+	l.Mut.Unlock()
+
+	tmp := l.path + ".rewrite"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err == nil {
+		w := bufio.NewWriter(f)
+		for k, cfg := range c.copyConfigs() {
+			r := &aofRecord{Op: aofConfig, Bulk: k, Config: cfg}
+			if _, err = w.Write(r.encode()); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = c.eachAlive(func(bulk, sub string, i *Item) error {
+				r := &aofRecord{Op: aofAdd, Bulk: bulk, Sub: sub, Data: i.Data, Expire: i.Expire}
+				_, err := w.Write(r.encode())
+				return err
+			})
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+	}
+
+	l.Mut.Lock()
+	defer l.Mut.Unlock()
+	pending := l.rewrite
+	l.rewrite = nil
+	if f == nil {
+		return err
+	}
+	if err == nil {
+		_, err = f.Write(pending.Bytes())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	nf, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file = nf
+	l.dirty = false
+	return nil
+}
+
+func (l *AppendLog) Close() error {
+	l.Mut.Lock()
+	defer l.Mut.Unlock()
+	close(l.stop)
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+func (r *aofRecord) encode() []byte {
+	p := &bytes.Buffer{}
+	p.WriteByte(r.Op)
+	switch r.Op {
+	case aofAdd:
+		writeField(p, []byte(r.Bulk))
+		writeField(p, []byte(r.Sub))
+		writeField(p, r.Data)
+		b := make([]byte, binary.MaxVarintLen64)
+		p.Write(b[:binary.PutVarint(b, r.Expire.UnixNano())])
+	case aofDelete:
+		writeField(p, []byte(r.Bulk))
+		writeField(p, []byte(r.Sub))
+	case aofRemove:
+		writeField(p, []byte(r.Bulk))
+	case aofConfig:
+		writeField(p, []byte(r.Bulk))
+		b := make([]byte, binary.MaxVarintLen64)
+		for _, n := range []int64{int64(r.Config.MaxItem), int64(r.Config.Eliminate), int64(r.Config.EvictionPolicy), int64(r.Config.Shards)} {
+			p.Write(b[:binary.PutVarint(b, n)])
+		}
+		if r.Config.EnabledCache {
+			p.WriteByte(1)
+		} else {
+			p.WriteByte(0)
+		}
+	}
+	payload := p.Bytes()
+	buf := make([]byte, aofHeaderSize, aofHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+func writeField(w *bytes.Buffer, f []byte) {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.Write(b[:binary.PutUvarint(b, uint64(len(f)))])
+	w.Write(f)
+}
+
+func readField(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrCorruptRecord
+	}
+	if n > uint64(r.Len()) {
+		return nil, ErrCorruptRecord
+	}
+	f := make([]byte, n)
+	r.Read(f)
+	return f, nil
+}
+
+// readRecord returns the record and its size, io.EOF at the clean end of the log.
+// errTornRecord is a record cut by the end of the log, ErrCorruptRecord of
+// size 0 is a broken length which the following records can not be found after
+func readRecord(r io.Reader) (*aofRecord, int, error) {
+	h := make([]byte, aofHeaderSize)
+	if _, err := io.ReadFull(r, h); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errTornRecord
+	}
+	n := binary.BigEndian.Uint32(h[0:4])
+	if n == 0 || n > aofMaxRecord {
+		return nil, 0, ErrCorruptRecord
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, errTornRecord
+	}
+	size := aofHeaderSize + int(n)
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(h[4:8]) {
+		return nil, size, ErrCorruptRecord
+	}
+	rec, err := decodeRecord(payload)
+	return rec, size, err
+}
+
+func decodeRecord(payload []byte) (*aofRecord, error) {
+	p := bytes.NewReader(payload)
+	op, _ := p.ReadByte()
+	rec := &aofRecord{Op: op}
+	switch op {
+	case aofAdd, aofDelete, aofRemove:
+		b, err := readField(p)
+		if err != nil {
+			return nil, err
+		}
+		rec.Bulk = string(b)
+		if op == aofRemove {
+			break
+		}
+		s, err := readField(p)
+		if err != nil {
+			return nil, err
+		}
+		rec.Sub = string(s)
+		if op == aofDelete {
+			break
+		}
+		if rec.Data, err = readField(p); err != nil {
+			return nil, err
+		}
+		ex, err := binary.ReadVarint(p)
+		if err != nil {
+			return nil, ErrCorruptRecord
+		}
+		rec.Expire = time.Unix(0, ex)
+	case aofConfig:
+		b, err := readField(p)
+		if err != nil {
+			return nil, err
+		}
+		rec.Bulk = string(b)
+		ns := make([]int64, 4)
+		for i := range ns {
+			if ns[i], err = binary.ReadVarint(p); err != nil {
+				return nil, ErrCorruptRecord
+			}
+		}
+		cache, err := p.ReadByte()
+		if err != nil {
+			return nil, ErrCorruptRecord
+		}
+		rec.Config = &BulkConfig{
+			MaxItem:        int(ns[0]),
+			Eliminate:      time.Duration(ns[1]),
+			EvictionPolicy: EvictionPolicy(ns[2]),
+			Shards:         int(ns[3]),
+			EnabledCache:   cache == 1,
+		}
+	case aofFlush:
+	default:
+		return nil, ErrCorruptRecord
+	}
+	return rec, nil
+}
+
+// ReplayAppendLog applies the records of path to c. A torn tail left by a crash
+// is truncated so new records are appended after the last good one, a corrupt
+// record in the middle is skipped, a broken length fails the replay
+func (c *Container) ReplayAppendLog(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	records, skipped := 0, 0
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err == errTornRecord {
+			c.Log.Warning(fmt.Sprintf("Append log %s is torn at offset %d, truncated", path, offset))
+			if err := os.Truncate(path, offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil && n == 0 {
+			return fmt.Errorf("Append log %s is corrupt at offset %d", path, offset)
+		}
+		offset += int64(n)
+		if err != nil {
+			c.Log.Warning(fmt.Sprintf("Skip corrupt record of append log %s at offset %d", path, offset-int64(n)))
+			skipped++
+			continue
+		}
+		records++
+		c.apply(rec)
+	}
+	c.Log.Info(fmt.Sprintf("Replay %d records from append log %s, %d corrupt skipped", records, path, skipped))
+	return nil
+}
+
+func (c *Container) apply(rec *aofRecord) {
+	switch rec.Op {
+	case aofAdd:
+		if !rec.Expire.After(time.Now()) {
+			return
+		}
+		addAt(c.AddBulk(rec.Bulk, nil), rec.Sub, rec.Data, rec.Expire)
+	case aofDelete:
+		if b, ok := c.GetBulk(rec.Bulk); ok {
+			b.Delete(rec.Sub)
+		}
+	case aofRemove:
+		c.removeBulk(rec.Bulk)
+		c.Mut.Lock()
+		delete(c.configs, rec.Bulk)
+		c.Mut.Unlock()
+	case aofConfig:
+		if _, err := c.setBulkConfig(rec.Bulk, rec.Config); err != nil {
+			c.Log.Warning(fmt.Sprintf("Skip config of bulk %s in append log error[%s]", rec.Bulk, err.Error()))
+		}
+	case aofFlush:
+		c.flush()
+	}
+}
@@ -0,0 +1,179 @@
+package bulkCache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_AppendLog(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bulkd")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bulkd.aof")
+
+	c, _ := NewContainer("Log", BTreeEngine)
+	if err := c.OpenAppendLog(path, SyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	for j := 0; j < 3; j++ {
+		for i := 0; i < 10; i++ {
+			c.Add(fmt.Sprintf("Video %d", j), fmt.Sprint(i), []byte(fmt.Sprintf("Tag %d", i)), time.Minute)
+		}
+	}
+	c.Add("Video 0", "0", []byte("Tag 00"), time.Minute)
+	c.Add("Expired", "0", []byte("Tag 0"), time.Millisecond)
+	c.DeleteItem("Video 1", "1")
+	c.Remove("Video 2")
+	c.CloseAppendLog()
+
+	//broken tail record
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0, 0, 0, 9, 1, 2})
+	f.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	r, _ := NewContainer("Replay", HashEngine)
+	if err := r.OpenAppendLog(path, SyncEverySecond); err != nil {
+		t.Fatal(err)
+	}
+	if its, _ := r.Get("Video 0"); len(its) != 10 {
+		t.Errorf("Video 0 should have 10 items, got %d", len(its))
+	}
+	if i, _ := r.GetItem("Video 0", "0"); i == nil || string(i.Data) != "Tag 00" {
+		t.Error("overwritten item is not replayed")
+	}
+	if its, _ := r.Get("Video 1"); len(its) != 9 {
+		t.Errorf("Video 1 should have 9 items, got %d", len(its))
+	}
+	if r.Has("Video 2") || r.Has("Expired") {
+		t.Error("removed or expired bulk is replayed")
+	}
+
+	before, _ := os.Stat(path)
+	if err := r.RewriteAppendLog(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("rewrite should compact log, %d => %d", before.Size(), after.Size())
+	}
+	r.Flush()
+	r.Add("Video 3", "0", []byte("Tag 0"), time.Minute)
+	r.CloseAppendLog()
+
+	n, _ := NewContainer("Rewrite", BTreeEngine)
+	if err := n.OpenAppendLog(path, SyncNever); err != nil {
+		t.Fatal(err)
+	}
+	defer n.CloseAppendLog()
+	if n.Has("Video 0") || !n.Has("Video 3") {
+		t.Error("flush is not replayed after rewrite")
+	}
+}
+
+func Test_AppendLogReplay(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bulkd")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bulkd.aof")
+
+	c, _ := NewContainer("Log", HashEngine)
+	if err := c.OpenAppendLog(path, SyncNever); err != nil {
+		t.Fatal(err)
+	}
+	c.AddBulk("Full", &BulkConfig{MaxItem: 2, Eliminate: time.Second, EvictionPolicy: EvictOldestExpire})
+	for i := 0; i < 3; i++ {
+		c.Add("Full", fmt.Sprint(i), []byte("Tag"), time.Minute*time.Duration(i+1))
+	}
+	c.Add("Video", "a", []byte("Tag a"), time.Minute)
+	c.Add("Video", "b", []byte("Tag b"), time.Minute)
+	c.Add("Video", "c", []byte("Tag c"), time.Minute)
+	want, _ := c.GetItem("Video", "c")
+	c.CloseAppendLog()
+
+	//corrupt payload of the add record of Video a, records after it are kept
+	data, _ := ioutil.ReadFile(path)
+	offset := 0
+	for {
+		rec, n, err := readRecord(bytes.NewReader(data[offset:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Op == aofAdd && rec.Bulk == "Video" {
+			data[offset+n-1] ^= 0xff
+			break
+		}
+		offset += n
+	}
+	ioutil.WriteFile(path, data, 0644)
+
+	r, _ := NewContainer("Replay", HashEngine)
+	if err := r.OpenAppendLog(path, SyncNever); err != nil {
+		t.Fatal(err)
+	}
+	defer r.CloseAppendLog()
+	if _, ok := r.GetItem("Full", "0"); ok {
+		t.Error("evicted item is replayed")
+	}
+	if its, _ := r.Get("Full"); len(its) != 2 {
+		t.Errorf("Full should have 2 items, got %d", len(its))
+	}
+	if _, ok := r.GetItem("Video", "a"); ok {
+		t.Error("corrupt record is replayed")
+	}
+	i, ok := r.GetItem("Video", "c")
+	if _, okb := r.GetItem("Video", "b"); !ok || !okb {
+		t.Fatal("records after a corrupt one are dropped")
+	}
+	if !i.Expire.Equal(want.Expire) {
+		t.Errorf("replayed expire %s differs from stored %s", i.Expire, want.Expire)
+	}
+
+	//a broken length can not be skipped
+	binary.BigEndian.PutUint32(data[offset:], 0)
+	ioutil.WriteFile(path, data, 0644)
+	b, _ := NewContainer("Broken", HashEngine)
+	if err := b.ReplayAppendLog(path); err == nil {
+		t.Error("broken length should fail replay")
+	}
+}
+
+func Test_AppendLogConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bulkd")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bulkd.aof")
+
+	c, _ := NewContainer("Log Config", ShardedEngine)
+	if err := c.OpenAppendLog(path, SyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	want := BulkConfig{MaxItem: 4, Eliminate: time.Second, EvictionPolicy: EvictLRU, Shards: 2, EnabledCache: true}
+	if _, err := c.SetBulkConfig("Video", &want); err != nil {
+		t.Fatal(err)
+	}
+	//config of an emptied bulk is kept
+	c.SetBulkConfig("Audio", &BulkConfig{MaxItem: 2, Eliminate: time.Second})
+	c.CloseAppendLog()
+
+	for _, name := range []string{"Replay Config", "Rewrite Config"} {
+		r, _ := NewContainer(name, ShardedEngine)
+		if err := r.OpenAppendLog(path, SyncNever); err != nil {
+			t.Fatal(err)
+		}
+		if cfg, ok := r.BulkConfig("Video"); !ok || *cfg != want {
+			t.Errorf("[%s] config is not replayed, got %+v", name, cfg)
+		}
+		r.Remove("Audio")
+		if err := r.RewriteAppendLog(); err != nil {
+			t.Fatal(err)
+		}
+		r.CloseAppendLog()
+		if _, ok := r.BulkConfig("Audio"); ok {
+			t.Errorf("[%s] config of removed bulk is kept", name)
+		}
+	}
+}
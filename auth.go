@@ -0,0 +1,154 @@
+package bulkCache
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	ReadOnly  = "ro"
+	ReadWrite = "rw"
+)
+
+var (
+	ErrUnauthorized = errors.New("Unauthorized")
+	ErrDenied       = errors.New("Permission denied")
+)
+
+type (
+	// Token grants access to Dage, HTTP and RESP apis.
+	// A read only token can not change any bulk, a token with prefixes
+	// only changes bulks whose name starts with one of them
+	Token struct {
+		Secret   string
+		ReadOnly bool
+		Prefixes []string
+	}
+
+	// Auth holds tokens, a nil Auth lets everyone in
+	Auth struct {
+		Mut    *sync.RWMutex
+		tokens []*Token
+	}
+)
+
+func NewAuth() *Auth {
+	return &Auth{Mut: &sync.RWMutex{}}
+}
+
+// LoadTokens reads tokens file, see ParseTokens
+func LoadTokens(path string) (*Auth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTokens(f)
+}
+
+// ParseTokens reads a token per line as
+//	secret [ro|rw] [prefix,prefix...]
+// mode defaults to rw, blank lines and lines starting with # are skipped
+func ParseTokens(r io.Reader) (*Auth, error) {
+	a := NewAuth()
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		fs := strings.Fields(s.Text())
+		if len(fs) == 0 || strings.HasPrefix(fs[0], "#") {
+			continue
+		}
+		if len(fs) > 3 {
+			return nil, fmt.Errorf("Invalid token at line %d", line)
+		}
+		t := &Token{Secret: fs[0]}
+		if len(fs) > 1 {
+			switch fs[1] {
+			case ReadOnly:
+				t.ReadOnly = true
+			case ReadWrite:
+			default:
+				return nil, fmt.Errorf("Unknown token mode %s at line %d", fs[1], line)
+			}
+		}
+		if len(fs) > 2 {
+			t.Prefixes = strings.Split(fs[2], ",")
+		}
+		a.Add(t)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Auth) Add(t *Token) {
+	a.Mut.Lock()
+	defer a.Mut.Unlock()
+	a.tokens = append(a.tokens, t)
+}
+
+func (a *Auth) Len() int {
+	a.Mut.RLock()
+	defer a.Mut.RUnlock()
+	return len(a.tokens)
+}
+
+// Authenticate returns the token of secret, secrets are compared in constant time
+func (a *Auth) Authenticate(secret string) (*Token, error) {
+	a.Mut.RLock()
+	defer a.Mut.RUnlock()
+	var found *Token
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Secret), []byte(secret)) == 1 {
+			found = t
+		}
+	}
+	if found == nil {
+		return nil, ErrUnauthorized
+	}
+	return found, nil
+}
+
+// CanWrite tells whether token may change bulk
+func (t *Token) CanWrite(bulk string) bool {
+	if t.ReadOnly {
+		return false
+	}
+	if len(t.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range t.Prefixes {
+		if strings.HasPrefix(bulk, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permit checks token of a connection against auth,
+// write tells the command changes bulks
+func (a *Auth) Permit(t *Token, write bool, bulks ...string) error {
+	if a == nil {
+		return nil
+	}
+	if t == nil {
+		return ErrUnauthorized
+	}
+	if !write {
+		return nil
+	}
+	for _, b := range bulks {
+		if !t.CanWrite(b) {
+			return ErrDenied
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package bulkCache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTokens = `
+# token mode prefixes
+admin
+reader  ro
+feeder  rw  video:,audio:
+`
+
+func Test_ParseTokens(t *testing.T) {
+	a, err := ParseTokens(strings.NewReader(testTokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Len() != 3 {
+		t.Fatalf("parse %d tokens, want 3", a.Len())
+	}
+	if _, err := a.Authenticate("nobody"); err != ErrUnauthorized {
+		t.Error("unknown token should be unauthorized")
+	}
+	feeder, _ := a.Authenticate("feeder")
+	reader, _ := a.Authenticate("reader")
+	admin, _ := a.Authenticate("admin")
+	cases := []struct {
+		token *Token
+		write bool
+		bulk  string
+		want  error
+	}{
+		{nil, false, "video:auth", ErrUnauthorized},
+		{reader, false, "video:auth", nil},
+		{reader, true, "video:auth", ErrDenied},
+		{feeder, true, "audio:1", nil},
+		{feeder, true, "image:auth", ErrDenied},
+		{admin, true, "image:auth", nil},
+	}
+	for _, c := range cases {
+		if err := a.Permit(c.token, c.write, c.bulk); err != c.want {
+			t.Errorf("permit %v write %v to %s get %v, want %v", c.token, c.write, c.bulk, err, c.want)
+		}
+	}
+	var open *Auth
+	if err := open.Permit(nil, true, "video:auth"); err != nil {
+		t.Error("nil auth should permit everyone")
+	}
+	if _, err := ParseTokens(strings.NewReader("admin rwx")); err == nil {
+		t.Error("unknown mode should fail")
+	}
+}
+
+func Test_DageAuth(t *testing.T) {
+	db, _ := NewContainer("", "")
+	d := NewDage(db)
+	auth, err := ParseTokens(strings.NewReader(testTokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Auth = auth
+	d.Listen("127.0.0.1:0")
+	defer d.Listener.Close()
+	ctx := context.Background()
+
+	for _, binary := range []bool{true, false} {
+		c := NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		if err := c.Ping(ctx); err != nil {
+			t.Errorf("ping without token error[%s]", err.Error())
+		}
+		if err := c.Set(ctx, "video:auth", "a", []byte("a"), time.Minute); err != ErrDenied {
+			t.Errorf("set without token error[%v]", err)
+		}
+		c.Close()
+
+		c = NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		c.Token = "feeder"
+		if err := c.Set(ctx, "video:auth", "a", []byte("a"), time.Minute); err != nil {
+			t.Errorf("feeder set error[%v]", err)
+		}
+		if err := c.Set(ctx, "image:auth", "a", []byte("a"), time.Minute); err != ErrDenied {
+			t.Errorf("feeder set out of prefix error[%v]", err)
+		}
+		c.Close()
+
+		c = NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		c.Token = "reader"
+		if values, err := c.Get(ctx, "video:auth"); err != nil || len(values) != 1 {
+			t.Errorf("reader get %q error[%v]", values, err)
+		}
+		if err := c.Remove(ctx, "video:auth"); err != ErrDenied {
+			t.Errorf("reader remove error[%v]", err)
+		}
+		c.Close()
+
+		c = NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		c.Token = "wrong"
+		if err := c.Ping(ctx); err != ErrUnauthorized {
+			t.Errorf("wrong token error[%v]", err)
+		}
+		c.Close()
+	}
+}
+
+func Test_RespAuth(t *testing.T) {
+	db, _ := NewContainer("", "")
+	s := NewRespServer(db)
+	auth, err := ParseTokens(strings.NewReader(testTokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Auth = auth
+	s.Listen("127.0.0.1:0")
+	defer s.Listener.Close()
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	r := bufio.NewReader(conn)
+
+	cases := []struct {
+		cmd  []string
+		want string
+	}{
+		{[]string{"HGET", "video:auth", "a"}, "-NOAUTH Authentication required."},
+		{[]string{"AUTH", "wrong"}, "-WRONGPASS invalid username-password pair"},
+		{[]string{"AUTH", "default", "reader"}, "+OK"},
+		{[]string{"HGET", "video:auth", "a"}, "nil"},
+		{[]string{"HSET", "video:auth", "a", "1"}, "-NOPERM Permission denied"},
+		{[]string{"AUTH", "feeder"}, "+OK"},
+		{[]string{"HSET", "video:auth", "a", "1"}, ":1"},
+		{[]string{"DEL", "video:auth", "image:auth"}, "-NOPERM Permission denied"},
+	}
+	for _, c := range cases {
+		conn.Write([]byte(respCommand(c.cmd...)))
+		got, err := readRespReply(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%v get %q, want %q", c.cmd, got, c.want)
+		}
+	}
+}
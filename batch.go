@@ -0,0 +1,105 @@
+package bulkCache
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	BatchItem struct {
+		Bulk   string
+		Key    string
+		Value  []byte
+		Expire time.Duration
+		at     time.Time //deadline fixed by Container.AddMany, zero is Expire after adding
+	}
+
+	// BatchBulk adds many items under one lock, Bulk of items is ignored
+	BatchBulk interface {
+		Bulk
+		AddMany(items []BatchItem) []error
+	}
+
+	// PersistError is the error of an item added to its bulk but not to the append log
+	PersistError struct {
+		Err error
+	}
+)
+
+func (e *PersistError) Error() string {
+	return fmt.Sprintf("Added but append log error[%s]", e.Err.Error())
+}
+
+// deadline returns when item expires if it is added at n
+func (i BatchItem) deadline(n time.Time) time.Time {
+	if !i.at.IsZero() {
+		return i.at
+	}
+	return n.Add(i.Expire)
+}
+
+// AddMany adds items to their bulks, items of a bulk are added under one lock
+// when the engine supports it. Errors are in order of items, nil means added,
+// a *PersistError means added but missing in the append log
+func (c *Container) AddMany(items []BatchItem) []error {
+	errs := make([]error, len(items))
+	order := []string{}
+	groups := map[string][]BatchItem{}
+	indexes := map[string][]int{}
+	now := time.Now()
+	for n, i := range items {
+		if i.Key == "" {
+			key, err := GenerateKey()
+			if err != nil {
+				c.Log.Error(fmt.Sprintf("Generate Key[%d byte] error[%s]", KeySize, err.Error()))
+				errs[n] = err
+				continue
+			}
+			i.Key = key
+		}
+		i.Key = PaddingKey(i.Key)
+		i.at = i.deadline(now)
+		if _, ok := groups[i.Bulk]; !ok {
+			order = append(order, i.Bulk)
+		}
+		groups[i.Bulk] = append(groups[i.Bulk], i)
+		indexes[i.Bulk] = append(indexes[i.Bulk], n)
+	}
+
+	for _, key := range order {
+		batch := groups[key]
+		var bulk Bulk
+		if !c.Has(key) {
+			bulk = c.AddBulk(key, nil)
+		} else {
+			bulk, _ = c.GetBulk(key)
+		}
+		var results []error
+		if bb, ok := bulk.(BatchBulk); ok {
+			results = bb.AddMany(batch)
+		} else {
+			results = make([]error, len(batch))
+			for n, i := range batch {
+				results[n] = addAt(bulk, i.Key, i.Value, i.at)
+			}
+		}
+		l := c.aof()
+		for j, err := range results {
+			errs[indexes[key][j]] = err
+			if err != nil {
+				continue
+			}
+			i := batch[j]
+			//subscribers see items already in the append log
+			if l != nil {
+				if err := l.Add(key, i.Key, i.Value, i.at); err != nil {
+					c.Log.Error(fmt.Sprintf("Append Add to log error[%s]", err.Error()))
+					errs[indexes[key][j]] = &PersistError{Err: err}
+				}
+			}
+			c.publishItem(EventAdd, key, i.Key, &Item{Data: i.Value, Expire: i.at})
+		}
+	}
+	c.checkMemory()
+	return errs
+}
@@ -0,0 +1,75 @@
+package bulkCache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_AddMany(t *testing.T) {
+	for _, engine := range Engines() {
+		c, err := NewContainer("Batch", engine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.AddBulk("Full", &BulkConfig{MaxItem: 1, Eliminate: time.Second, Shards: 1})
+		items := []BatchItem{}
+		for i := 0; i < 10; i++ {
+			items = append(items, BatchItem{Bulk: fmt.Sprintf("Video %d", i%2), Key: fmt.Sprint(i), Value: []byte(fmt.Sprintf("Tag %d", i)), Expire: time.Minute})
+		}
+		items = append(items, BatchItem{Bulk: "Full", Key: "a", Value: []byte("a"), Expire: time.Minute})
+		items = append(items, BatchItem{Bulk: "Full", Key: "b", Value: []byte("b"), Expire: time.Minute})
+		items = append(items, BatchItem{Bulk: "Video 0", Value: []byte("generated key"), Expire: time.Minute})
+
+		errs := c.AddMany(items)
+		if len(errs) != len(items) {
+			t.Fatalf("%s get %d errors, want %d", engine, len(errs), len(items))
+		}
+		for n, err := range errs {
+			if (err != nil) != (n == 11) {
+				t.Errorf("%s item %d error[%v]", engine, n, err)
+			}
+		}
+		if its, _ := c.Get("Video 0"); len(its) != 6 {
+			t.Errorf("%s bulk Video 0 has %d items, want 6", engine, len(its))
+		}
+		if i, ok := c.GetItem("Video 1", "9"); !ok || string(i.Data) != "Tag 9" {
+			t.Errorf("%s get item %v", engine, i)
+		}
+		if i, ok := c.GetItem("Full", "a"); !ok || string(i.Data) != "a" {
+			t.Errorf("%s full bulk keeps %v", engine, i)
+		}
+	}
+}
+
+func Test_AddManyPersistError(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bulkd")
+	defer os.RemoveAll(dir)
+	c, _ := NewContainer("Batch Log", HashEngine)
+	if err := c.OpenAppendLog(filepath.Join(dir, "bulkd.aof"), SyncNever); err != nil {
+		t.Fatal(err)
+	}
+	sub := c.Subscribe("Video", EventAdd)
+	defer sub.Close()
+	//writes to the log fail from now on
+	c.aof().file.Close()
+
+	errs := c.AddMany([]BatchItem{{Bulk: "Video", Key: "a", Value: []byte("a"), Expire: time.Minute}})
+	if _, ok := errs[0].(*PersistError); !ok {
+		t.Fatalf("append log error is not reported apart, got %v", errs[0])
+	}
+	if i, ok := c.GetItem("Video", "a"); !ok || string(i.Data) != "a" {
+		t.Error("item missing in the append log is not added")
+	}
+	select {
+	case e := <-sub.C:
+		if e.Key != PaddingKey("a") {
+			t.Errorf("event of %s is published", e.Key)
+		}
+	case <-time.After(time.Second):
+		t.Error("event of added item is not published")
+	}
+}
@@ -1,7 +1,6 @@
 package bulkCache
 
 import (
-	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -13,74 +12,171 @@ import (
 type (
 	BTreeBulk struct {
 		tree       *btree.Tree
+		index      map[string]string //sub key => tree key
+		subs       *btree.Tree       //sub key => item, ordered for Scan
 		analytics  *Analytics
 		Mut        *sync.RWMutex
 		config     *BulkConfig
 		timeFormat string
+		handler    EventHandler
 		stop       bool
+		//runs eliminate pass on the wheel of container, nil runs Eliminate
+		eliminator *eliminator
 	}
 )
 
-func GenerateTree(cached Cached) *btree.Tree {
-	tree := btree.NewWithStringComparator(3)
-	for k, v := range cached {
-		tree.Put(k, v)
-	}
-	return tree
-}
-
 func NewDefaultBTreeBulkConfig() *BulkConfig {
 	return &BulkConfig{
 		MaxItem:      (1 << 16) - 1,
-		Eliminate:    500,
+		Eliminate:    time.Duration(time.Millisecond * 500),
 		EnabledCache: false,
 	}
 }
 
 func NewBTreeBulk(cfg *BulkConfig) *BTreeBulk {
+	return NewBTreeBulkFromCached(cfg, Cached{})
+}
+
+func NewBTreeBulkFromCached(cfg *BulkConfig, cached Cached) *BTreeBulk {
+	b := newBTreeBulk(cfg, cached)
+	go b.Eliminate()
+	return b
+}
+
+// newBTreeBulk creates a btree bulk without eliminate goroutine
+func newBTreeBulk(cfg *BulkConfig, cached Cached) *BTreeBulk {
 	if cfg == nil {
 		cfg = NewDefaultBTreeBulkConfig()
 	}
-	return &BTreeBulk{
+	b := &BTreeBulk{
 		tree:       btree.NewWithStringComparator(3),
+		index:      map[string]string{},
+		subs:       btree.NewWithStringComparator(3),
 		analytics:  NewAnalytics(),
 		Mut:        &sync.RWMutex{},
 		config:     cfg,
-		timeFormat: "2006-01-02 15:04:05",
+		timeFormat: "2006-01-02 15:04:05.000000000",
+	}
+	for k, v := range cached {
+		b.put(k, v)
+		b.analytics.store(k, v.Data)
 	}
+	return b
 }
 
-func NewBTreeBulkFromCached(cfg *BulkConfig, cached Cached) *BTreeBulk {
-	b := NewBTreeBulk(cfg)
-	b.tree = GenerateTree(cached)
-	b.Mut = &sync.RWMutex{}
-	b.analytics = NewAnalytics()
-	return b
+func (b *BTreeBulk) OnEvent(handler EventHandler) {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	b.handler = handler
+}
+
+// notify reports an event of item, caller must hold the lock
+func (b *BTreeBulk) notify(t EventType, key string, i *Item) {
+	if b.handler != nil {
+		b.handler(t, key, i)
+	}
+}
+
+func (b *BTreeBulk) treeKey(key string, ex time.Time) string {
+	return fmt.Sprintf("%s:%s", ex.UTC().Format(b.timeFormat), key)
+}
+
+// put replaces the item of sub key and returns the replaced one,
+// caller must hold the lock
+func (b *BTreeBulk) put(key string, item *Item) *Item {
+	var old *Item
+	if tk, ok := b.index[key]; ok {
+		val, _ := b.tree.Get(tk)
+		old, _ = val.(*Item)
+		b.tree.Remove(tk)
+	}
+	tk := b.treeKey(key, item.Expire)
+	b.index[key] = tk
+	b.tree.Put(tk, item)
+	b.subs.Put(key, item)
+	return old
+}
+
+// remove deletes the item of sub key, caller must hold the lock
+func (b *BTreeBulk) remove(key string) *Item {
+	tk, ok := b.index[key]
+	if !ok {
+		return nil
+	}
+	val, _ := b.tree.Get(tk)
+	b.tree.Remove(tk)
+	b.subs.Remove(key)
+	delete(b.index, key)
+	i, _ := val.(*Item)
+	return i
+}
+
+// expireTreeKey deletes the expired item stored under tree key,
+// caller must hold the lock
+func (b *BTreeBulk) expireTreeKey(tk string) {
+	val, ok := b.tree.Get(tk)
+	if !ok {
+		return
+	}
+	key := b.subKey(tk)
+	if b.index[key] == tk {
+		delete(b.index, key)
+		b.subs.Remove(key)
+	}
+	b.tree.Remove(tk)
+	i, _ := val.(*Item)
+	if i != nil {
+		b.analytics.Expired(key, i.Data)
+	}
+	b.notify(EventExpire, key, i)
+}
+
+// subKey strips the expire prefix of tree key
+func (b *BTreeBulk) subKey(tk string) string {
+	return tk[len(b.timeFormat)+1:]
 }
 
 func (b *BTreeBulk) Config() *BulkConfig {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
 	return b.config
 }
 
+// SetConfig replaces config, items over MaxItem are evicted
+func (b *BTreeBulk) SetConfig(cfg *BulkConfig) error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	b.config = cfg
+	policy := cfg.EvictionPolicy
+	if policy == EvictReject {
+		policy = EvictOldestExpire
+	}
+	for cfg.over(b.tree.Size()) {
+		if _, ok := b.evictBy(policy); !ok {
+			break
+		}
+	}
+	return nil
+}
+
 func (b *BTreeBulk) Analytics() *Analytics {
 	return b.analytics
 }
 
 func (b *BTreeBulk) Len() int {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
 	return b.tree.Size()
 }
 
-func (b *BTreeBulk) Bytes() (n int) {
-	it := b.tree.Iterator()
-	for it.Next() {
-		v, _ := it.Value().(*Item)
-		k, _ := it.Key().(string)
-		n += len(v.Data) + len(k)
-	}
-	return
+// Bytes returns bytes of sub keys and values accounted by analytics
+func (b *BTreeBulk) Bytes() int {
+	return int(b.analytics.Bytes())
 }
 
 func (b *BTreeBulk) String() string {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
 	s := []string{"**********BTree BULK**********"}
 	it := b.tree.Iterator()
 	for it.Next() {
@@ -92,23 +188,147 @@ func (b *BTreeBulk) String() string {
 }
 
 func (b *BTreeBulk) Add(key string, value []byte, expire time.Duration) error {
-	if b.tree.Size() > b.config.MaxItem && b.config.MaxItem != -1 {
-		return errors.New("Bulk is fulled")
-	}
+	return b.AddAt(key, value, time.Now().Add(expire))
+}
+
+// AddAt puts an item expiring at expire
+func (b *BTreeBulk) AddAt(key string, value []byte, expire time.Time) error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	return b.add(key, value, expire)
+}
+
+// AddMany adds items under one lock, errors are in order of items
+func (b *BTreeBulk) AddMany(items []BatchItem) []error {
 	b.Mut.Lock()
-	defer b.analytics.Add(value)
 	defer b.Mut.Unlock()
-	ex := time.Now().Add(expire)
-	key = fmt.Sprintf("%s:%s", ex.Format(b.timeFormat), key)
-	item := &Item{Data: value, Expire: ex}
-	b.tree.Put(key, item)
+	errs := make([]error, len(items))
+	now := time.Now()
+	for n, i := range items {
+		errs[n] = b.add(i.Key, i.Value, i.deadline(now))
+	}
+	return errs
+}
+
+// add puts an item expiring at expire, caller must hold the lock
+func (b *BTreeBulk) add(key string, value []byte, expire time.Time) error {
+	if _, ok := b.index[key]; !ok && b.config.full(b.tree.Size()) {
+		if err := b.evict(); err != nil {
+			return err
+		}
+	}
+	if old := b.put(key, NewItem(value, expire)); old != nil {
+		b.analytics.Removed(key, old.Data)
+	}
+	b.analytics.Add(key, value)
 	return nil
 }
 
-func (b *BTreeBulk) GetAlive() Cached {
+// evict removes one item by eviction policy of config, caller must hold the lock
+func (b *BTreeBulk) evict() error {
+	if b.config.EvictionPolicy == EvictReject {
+		return ErrBulkFull
+	}
+	if _, ok := b.evictBy(b.config.EvictionPolicy); !ok {
+		return ErrBulkFull
+	}
+	return nil
+}
+
+// evictBy removes one item by policy and returns bytes it frees, caller must hold the lock
+func (b *BTreeBulk) evictBy(policy EvictionPolicy) (int, bool) {
+	var key string
+	if policy == EvictOldestExpire {
+		//tree is ordered by expire
+		it := b.tree.Iterator()
+		if !it.Next() {
+			return 0, false
+		}
+		tk, _ := it.Key().(string)
+		key = b.subKey(tk)
+	} else {
+		samples := make([]evictionCandidate, 0, EvictionSamples)
+		for k, tk := range b.index {
+			val, _ := b.tree.Get(tk)
+			i, _ := val.(*Item)
+			samples = append(samples, evictionCandidate{key: k, item: i})
+			if len(samples) == EvictionSamples || policy == EvictRandom {
+				break
+			}
+		}
+		var ok bool
+		if key, ok = selectVictim(policy, samples); !ok {
+			return 0, false
+		}
+	}
+	i := b.remove(key)
+	if i == nil {
+		return 0, false
+	}
+	b.analytics.Evict(key, i.Data)
+	b.notify(EventEvict, key, i)
+	return itemBytes(key, i), true
+}
+
+func (b *BTreeBulk) Evict(policy EvictionPolicy) (int, bool) {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	return b.evictBy(policy)
+}
+
+func (b *BTreeBulk) Get(key string) *Item {
 	b.Mut.RLock()
+	defer b.analytics.Get()
 	defer b.Mut.RUnlock()
+	tk, ok := b.index[key]
+	if !ok {
+		return nil
+	}
+	val, _ := b.tree.Get(tk)
+	i, _ := val.(*Item)
+	if i == nil || time.Now().After(i.Expire) {
+		return nil
+	}
+	i.touch()
+	return i
+}
 
+func (b *BTreeBulk) Update(key string, value []byte, expire time.Duration) error {
+	return b.UpdateAt(key, value, time.Now().Add(expire))
+}
+
+// UpdateAt overwrites an alive item with one expiring at expire
+func (b *BTreeBulk) UpdateAt(key string, value []byte, expire time.Time) error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	tk, ok := b.index[key]
+	if !ok {
+		return ErrItemNotFound
+	}
+	val, _ := b.tree.Get(tk)
+	i, _ := val.(*Item)
+	if i == nil || time.Now().After(i.Expire) {
+		return ErrItemNotFound
+	}
+	b.analytics.Removed(key, i.Data)
+	b.analytics.Add(key, value)
+	b.put(key, NewItem(value, expire))
+	return nil
+}
+
+func (b *BTreeBulk) Delete(key string) bool {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	i := b.remove(key)
+	if i == nil {
+		return false
+	}
+	b.analytics.Removed(key, i.Data)
+	return true
+}
+
+func (b *BTreeBulk) GetAlive() Cached {
+	b.Mut.RLock()
 	n := time.Now()
 	cached := Cached{}
 	es := []string{}
@@ -117,52 +337,109 @@ func (b *BTreeBulk) GetAlive() Cached {
 		key, _ := it.Key().(string)
 		val, _ := it.Value().(*Item)
 		if n.Before(val.Expire) {
-			cached[key] = val
+			cached[b.subKey(key)] = val
 		} else {
 			es = append(es, key)
 		}
 	}
-	for _, e := range es {
-		b.tree.Remove(e)
+	b.Mut.RUnlock()
+
+	//expired items are removed under write lock
+	if len(es) > 0 {
+		b.Mut.Lock()
+		for _, e := range es {
+			b.expireTreeKey(e)
+		}
+		b.Mut.Unlock()
 	}
 	return cached
 }
 
 func (b *BTreeBulk) GetAliveInBulk() Bulk {
-	cached := b.GetAlive()
-	tree := btree.NewWithStringComparator(3)
-	for k, v := range cached {
-		tree.Put(k, v)
-	}
-	return &BTreeBulk{
-		config:    b.config,
-		Mut:       &sync.RWMutex{},
-		analytics: NewAnalytics(),
-		tree:      tree,
-	}
+	return newBTreeBulk(b.Config(), b.GetAlive())
+}
+
+// SetScheduler runs the eliminate pass of b on w instead of its own goroutine
+func (b *BTreeBulk) SetScheduler(w *TimingWheel) {
+	e := newEliminator(w, b.eliminate, func() time.Duration { return b.Config().Eliminate })
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	b.eliminator = e
 }
 
 func (b *BTreeBulk) Stop() {
+	b.Mut.Lock()
 	b.stop = true
+	e := b.eliminator
+	b.Mut.Unlock()
+	if e != nil {
+		e.stop()
+	}
 }
 
+// Eliminate removes expired items every config.Eliminate
 func (b *BTreeBulk) Eliminate() {
-	for !b.stop {
-		es := []string{}
-		it := b.tree.Iterator()
-		n := time.Now()
-		//low => high
-		for it.Next() {
-			val, _ := it.Value().(*Item)
-			key, _ := it.Key().(string)
-			if !n.After(val.Expire) {
-				break
-			}
-			es = append(es, key)
+	for {
+		<-time.After(b.Config().Eliminate)
+		if !b.eliminate(time.Now()) {
+			return
 		}
+	}
+}
 
-		for _, k := range es {
-			b.tree.Remove(k)
+// eliminate removes items expired at n, the tree is ordered by expire so a pass
+// stops at the first alive item, it returns false once bulk is stopped
+func (b *BTreeBulk) eliminate(n time.Time) bool {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	if b.stop {
+		return false
+	}
+	es := []string{}
+	it := b.tree.Iterator()
+	//low => high
+	for it.Next() {
+		val, _ := it.Value().(*Item)
+		key, _ := it.Key().(string)
+		if !n.After(val.Expire) {
+			break
 		}
+		es = append(es, key)
 	}
+	for _, k := range es {
+		b.expireTreeKey(k)
+	}
+	return true
+}
+
+// Range calls handler with alive items expiring in [from, to] ordered by expire,
+// until handler returns false or limit items are handled, limit <= 0 means no limit
+func (b *BTreeBulk) Range(from, to time.Time, limit int, handler RangeHandler) {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
+	if n := time.Now(); from.Before(n) {
+		from = n
+	}
+	//tree keys lead with expire, so the walk starts at the first key of from
+	begin := from.UTC().Format(b.timeFormat)
+	count := 0
+	seekTree(b.tree, begin, func(key string, v interface{}) bool {
+		val, _ := v.(*Item)
+		if val.Expire.Before(from) {
+			return true
+		}
+		if val.Expire.After(to) || !handler(b.subKey(key), val) {
+			return false
+		}
+		count++
+		return limit <= 0 || count < limit
+	})
+}
+
+// Scan seeks cursor in sub key order, cursor is the last sub key returned,
+// so an item overwritten with another expire is not returned again
+func (b *BTreeBulk) Scan(cursor string, count int) ([]KeyItem, string) {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
+	return scanTree(b.subs, cursor, count)
 }
@@ -45,3 +45,32 @@ func Test_BTreeBulk(t *testing.T) {
 		t.Log("eliminate success after 14 second")
 	}
 }
+
+func Test_BTreeRange(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine} {
+		c, _ := NewContainer("Range", engine)
+		n := time.Now()
+		for i := 9; i >= 0; i-- {
+			c.Add("Video", fmt.Sprint(i), []byte(fmt.Sprintf("Tag %d", i)), time.Millisecond*time.Duration(100*(i+1)))
+		}
+		its, err := c.Range("Video", n.Add(time.Millisecond*250), n.Add(time.Millisecond*750), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(its) != 5 {
+			t.Fatalf("[%s] range should return 5 items, got %d", engine, len(its))
+		}
+		for j, i := range its {
+			if string(i.Data) != fmt.Sprintf("Tag %d", j+2) {
+				t.Errorf("[%s] range item %d is %s", engine, j, i.Data)
+			}
+		}
+		its, _ = c.Range("Video", n, n.Add(time.Hour), 3)
+		if len(its) != 3 || string(its[0].Data) != "Tag 0" {
+			t.Errorf("[%s] range with limit failure", engine)
+		}
+		if _, err := c.Range("Missing", n, n, 0); err != ErrBulkNotFound {
+			t.Errorf("[%s] range of missing bulk should fail", engine)
+		}
+	}
+}
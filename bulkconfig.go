@@ -0,0 +1,120 @@
+package bulkCache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// MinEliminate is the shortest interval of eliminating expired items
+	MinEliminate = time.Millisecond * 10
+)
+
+var (
+	ErrInvalidEliminate  = fmt.Errorf("Eliminate must be at least %s", MinEliminate)
+	ErrInvalidShards     = errors.New("Shards must not be negative")
+	ErrShardsChanged     = errors.New("Shards of a sharded bulk can not change")
+	ErrShardsUnsupported = errors.New("Shards are only set for sharded engine")
+	ErrConfigUnsupported = errors.New("Engine of bulk can not change config")
+	ErrInvalidBulkConfig = errors.New("Bulk config is missing")
+)
+
+type (
+	// ConfigBulk is a bulk whose config changes in place,
+	// items over new MaxItem are evicted
+	ConfigBulk interface {
+		Bulk
+		SetConfig(cfg *BulkConfig) error
+	}
+)
+
+// Validate checks cfg before a bulk is created or changed by it,
+// any MaxItem is valid as 0 or negative means unlimited
+func (cfg *BulkConfig) Validate() error {
+	if cfg == nil {
+		return ErrInvalidBulkConfig
+	}
+	if cfg.Eliminate < MinEliminate {
+		return ErrInvalidEliminate
+	}
+	if _, ok := evictionPolicies[cfg.EvictionPolicy]; !ok {
+		return fmt.Errorf("Unknown eviction policy %s", cfg.EvictionPolicy)
+	}
+	if cfg.Shards < 0 {
+		return ErrInvalidShards
+	}
+	return nil
+}
+
+// SetBulkConfig creates bulk of key by cfg or changes config of the existing one,
+// cfg is kept for the bulk created again after it is emptied and written to the append log
+func (c *Container) SetBulkConfig(key string, cfg *BulkConfig) (Bulk, error) {
+	b, err := c.setBulkConfig(key, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if l := c.aof(); l != nil {
+		if err := l.Config(key, cfg); err != nil {
+			c.Log.Error(fmt.Sprintf("Append Config to log error[%s]", err.Error()))
+		}
+	}
+	return b, nil
+}
+
+// setBulkConfig is SetBulkConfig without append log, replay applies config records by it
+func (c *Container) setBulkConfig(key string, cfg *BulkConfig) (Bulk, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cp := *cfg
+	c.Mut.Lock()
+	b, ok := c.bulks[key]
+	//an existing bulk keeps its engine even if templates changed since
+	sharded := c.engineOf(key) == ShardedEngine
+	if ok {
+		_, sharded = b.(*ShardedBulk)
+	}
+	if cp.Shards != 0 && !sharded {
+		c.Mut.Unlock()
+		return nil, ErrShardsUnsupported
+	}
+	if !ok {
+		b = c.createBulk(key, &cp, nil)
+		c.attach(key, b)
+	}
+	c.Mut.Unlock()
+	if ok {
+		cb, ok := b.(ConfigBulk)
+		if !ok {
+			return nil, ErrConfigUnsupported
+		}
+		if err := cb.SetConfig(&cp); err != nil {
+			return nil, err
+		}
+	}
+	c.Mut.Lock()
+	c.configs[key] = &cp
+	c.Mut.Unlock()
+	c.Log.Info(fmt.Sprintf("Set config of bulk %s max item %d eliminate %s eviction %s", key, cp.MaxItem, cp.Eliminate, cp.EvictionPolicy))
+	return b, nil
+}
+
+// BulkConfig returns a copy of config of bulk, or the config it will be created by
+// if it is empty, false if neither SetBulkConfig nor a template gives one
+func (c *Container) BulkConfig(key string) (*BulkConfig, bool) {
+	c.Mut.RLock()
+	b, ok := c.bulks[key]
+	cfg := c.configs[key]
+	if t := c.template(key); cfg == nil && t != nil {
+		cfg = t.Config
+	}
+	c.Mut.RUnlock()
+	if ok {
+		cfg = b.Config()
+	} else if cfg == nil {
+		return nil, false
+	}
+	cp := *cfg
+	return &cp, true
+}
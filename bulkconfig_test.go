@@ -0,0 +1,136 @@
+package bulkCache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_BulkConfigValidate(t *testing.T) {
+	cases := map[*BulkConfig]error{
+		{MaxItem: 10, Eliminate: time.Second}:                      nil,
+		{MaxItem: -1, Eliminate: time.Second, Shards: 4}:           nil,
+		{MaxItem: 0, Eliminate: time.Second}:                       nil,
+		{MaxItem: -2, Eliminate: time.Second}:                      nil,
+		{MaxItem: 10, Eliminate: time.Millisecond}:                 ErrInvalidEliminate,
+		{MaxItem: 10, Eliminate: time.Second, Shards: -1}:          ErrInvalidShards,
+		{MaxItem: 10, Eliminate: time.Second, EvictionPolicy: 100}: nil,
+	}
+	for cfg, want := range cases {
+		err := cfg.Validate()
+		if cfg.EvictionPolicy == 100 {
+			if err == nil {
+				t.Error("unknown eviction policy is valid")
+			}
+			continue
+		}
+		if err != want {
+			t.Errorf("validate %+v got %v, want %v", cfg, err, want)
+		}
+	}
+}
+
+func Test_SetBulkConfig(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine, WheelEngine, ShardedEngine} {
+		c, _ := NewContainer("Config", engine)
+		if _, err := c.SetBulkConfig("Video", &BulkConfig{MaxItem: 10, Eliminate: time.Millisecond}); err != ErrInvalidEliminate {
+			t.Errorf("[%s] invalid config is accepted", engine)
+		}
+		if _, ok := c.BulkConfig("Video"); ok {
+			t.Errorf("[%s] config of invalid bulk is kept", engine)
+		}
+		for i := 0; i < 10; i++ {
+			c.Add("Video", fmt.Sprint(i), []byte("value"), time.Minute*time.Duration(i+1))
+		}
+		b, err := c.SetBulkConfig("Video", &BulkConfig{MaxItem: 4, Eliminate: time.Second, EvictionPolicy: EvictLRU})
+		if err != nil {
+			t.Fatalf("[%s] set config error[%s]", engine, err.Error())
+		}
+		if b.Len() != 4 {
+			t.Errorf("[%s] items over max item are not evicted, got %d", engine, b.Len())
+		}
+		if engine == ShardedEngine {
+			if _, err := c.SetBulkConfig("Video", &BulkConfig{MaxItem: 4, Eliminate: time.Second, Shards: 3}); err != ErrShardsChanged {
+				t.Errorf("[%s] shards are changed", engine)
+			}
+		} else if _, err := c.SetBulkConfig("Video", &BulkConfig{MaxItem: 4, Eliminate: time.Second, Shards: 3}); err != ErrShardsUnsupported {
+			t.Errorf("[%s] shards are set for engine without shards, got %v", engine, err)
+		}
+		cfg, ok := c.BulkConfig("Video")
+		if !ok || cfg.MaxItem != 4 || cfg.EvictionPolicy != EvictLRU {
+			t.Errorf("[%s] config %+v", engine, cfg)
+		}
+
+		//config is kept for the bulk created again
+		c.removeBulk("Video")
+		c.Add("Video", "a", []byte("value"), time.Minute)
+		if cfg, _ := c.BulkConfig("Video"); cfg.MaxItem != 4 {
+			t.Errorf("[%s] config is not kept, got %+v", engine, cfg)
+		}
+		c.Remove("Video")
+		if _, ok := c.BulkConfig("Video"); ok {
+			t.Errorf("[%s] config of removed bulk is kept", engine)
+		}
+	}
+}
+
+func Test_SetBulkConfigUnlimited(t *testing.T) {
+	c, _ := NewContainer("Config Unlimited", HashEngine)
+	for _, max := range []int{0, -1, -5} {
+		b, err := c.SetBulkConfig("Video", &BulkConfig{MaxItem: max, Eliminate: time.Second})
+		if err != nil {
+			t.Fatalf("max item %d error[%s]", max, err.Error())
+		}
+		for i := 0; i < 20; i++ {
+			c.Add("Video", fmt.Sprint(i), []byte("value"), time.Minute)
+		}
+		if b.Len() != 20 {
+			t.Errorf("max item %d holds %d items", max, b.Len())
+		}
+	}
+}
+
+func Test_SetBulkConfigShardsOfBulk(t *testing.T) {
+	c, _ := NewContainer("Config Shards", HashEngine)
+	c.Add("Video", "a", []byte("value"), time.Minute)
+	c.Add("Log", "a", []byte("value"), time.Minute)
+	//templates apply to bulks created later, existing bulks keep their engine
+	c.SetTemplates([]*BulkTemplate{{Pattern: "Video", Engine: ShardedEngine}})
+	if _, err := c.SetBulkConfig("Video", &BulkConfig{MaxItem: 4, Eliminate: time.Second, Shards: 4}); err != ErrShardsUnsupported {
+		t.Errorf("shards are set for hash bulk, got %v", err)
+	}
+
+	s, _ := NewContainer("Config Sharded", ShardedEngine)
+	s.Add("Video", "a", []byte("value"), time.Minute)
+	s.SetTemplates([]*BulkTemplate{{Pattern: "Video", Engine: HashEngine}})
+	if _, err := s.SetBulkConfig("Video", &BulkConfig{MaxItem: 4, Eliminate: time.Second, Shards: DefaultShards}); err != nil {
+		t.Errorf("shards of sharded bulk are rejected, got %v", err)
+	}
+}
+
+func Test_DageCreate(t *testing.T) {
+	d := newTestDage()
+	defer d.Listener.Close()
+	for _, binary := range []bool{true, false} {
+		c := NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		ctx := context.Background()
+		bulk := fmt.Sprintf("Create %v", binary)
+		if err := c.CreateBulk(ctx, bulk, &BulkConfig{MaxItem: 2, Eliminate: time.Second, EvictionPolicy: EvictOldestExpire}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			c.Set(ctx, bulk, fmt.Sprint(i), []byte("value"), time.Minute*time.Duration(i+1))
+		}
+		if vs, _ := c.Get(ctx, bulk); len(vs) != 2 {
+			t.Errorf("[binary %v] max item of created bulk is not applied, got %d", binary, len(vs))
+		}
+		err := c.CreateBulk(ctx, bulk, &BulkConfig{MaxItem: 2, Eliminate: time.Millisecond})
+		if err == nil || err.Error() != ErrInvalidEliminate.Error() {
+			t.Errorf("[binary %v] validation error is not returned, got %v", binary, err)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,737 @@
+package bulkCache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	ErrClientClosed   = errors.New("Dage client is closed")
+	ErrConnBroken     = errors.New("Dage connection is broken")
+	ErrCommandFailed  = errors.New("Dage command failed")
+	ErrInvalidValue   = errors.New("Value contains tab or newline")
+	ErrTextProtocol   = errors.New("Command needs text protocol")
+	ErrBinaryProtocol = errors.New("Command needs binary protocol")
+)
+
+type (
+	// DageClient talks Dage protocol over a pool of connections,
+	// requests are matched to responses by tick so a connection is shared by goroutines
+	DageClient struct {
+		Addr       string
+		Binary     bool        //negotiates binary protocol on connect, values may hold any byte
+		Token      string      //sent by AUTH on connect if not empty
+		Container  string      //sent by SELECT on connect if not empty
+		TLS        *tls.Config //dials TLS if not nil, ServerName defaults to host of Addr
+		MaxConns   int
+		Timeout    time.Duration //deadline of a request whose context has none
+		MinBackoff time.Duration
+		MaxBackoff time.Duration
+		Log        *log.Entry
+		Mut        *sync.Mutex
+		conns      []*dageConn
+		next       uint32
+		tick       uint64
+		closed     bool
+	}
+
+	dageConn struct {
+		Mut     *sync.Mutex
+		conn    net.Conn
+		binary  bool
+		pending map[uint64]chan *Frame
+		err     error
+	}
+)
+
+func NewDageClient() *DageClient {
+	return &DageClient{
+		Binary:     true,
+		MaxConns:   4,
+		Timeout:    time.Second * 5,
+		MinBackoff: time.Millisecond * 50,
+		MaxBackoff: time.Second * 5,
+		Mut:        &sync.Mutex{},
+		Log: log.WithFields(log.Fields{
+			"Api": "Dage client",
+		}),
+	}
+}
+
+// Dial creates a client of addr and checks the server by a PING
+func Dial(addr string) (*DageClient, error) {
+	c := NewDageClient()
+	c.Addr = addr
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	if err := c.Ping(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *DageClient) Ping(ctx context.Context) error {
+	if c.Binary {
+		return c.call(ctx, OpPing)
+	}
+	r, err := c.Do(ctx, Ping)
+	if err != nil {
+		return err
+	}
+	if r != Pong {
+		return ErrCommandFailed
+	}
+	return nil
+}
+
+// Set adds value to bulk, an empty key lets server generate one,
+// expire is rounded down to milliseconds, or seconds over text protocol
+func (c *DageClient) Set(ctx context.Context, bulk, key string, value []byte, expire time.Duration) error {
+	if c.Binary {
+		return c.call(ctx, OpSet, []byte(bulk), []byte(key), value, IntField(int64(expire/time.Millisecond)))
+	}
+	if strings.ContainsAny(string(value), "\t\n") {
+		return ErrInvalidValue
+	}
+	r, err := c.Do(ctx, Set, bulk, key, string(value), strconv.Itoa(int(expire/time.Second)))
+	if err != nil {
+		return err
+	}
+	return textStatus(r)
+}
+
+// Get returns alive values of bulk, a missing bulk has no values
+func (c *DageClient) Get(ctx context.Context, bulk string) ([][]byte, error) {
+	if c.Binary {
+		f, err := c.Call(ctx, OpGet, []byte(bulk))
+		if err != nil {
+			return nil, err
+		}
+		if f.Code == StatusNotFound {
+			return [][]byte{}, nil
+		}
+		if f.Code != StatusOK {
+			return nil, ErrCommandFailed
+		}
+		return f.Fields, nil
+	}
+	r, err := c.Do(ctx, GET, bulk)
+	if err != nil {
+		return nil, err
+	}
+	values := [][]byte{}
+	if r == "" {
+		return values, nil
+	}
+	for _, v := range strings.Split(r, "\t\t") {
+		values = append(values, []byte(v))
+	}
+	return values, nil
+}
+
+func (c *DageClient) Remove(ctx context.Context, bulk string) error {
+	if c.Binary {
+		return c.call(ctx, OpRemove, []byte(bulk))
+	}
+	r, err := c.Do(ctx, Remove, bulk)
+	if err != nil {
+		return err
+	}
+	return textStatus(r)
+}
+
+// MSet adds items in one request, the result of each item is in order of items
+func (c *DageClient) MSet(ctx context.Context, items []BatchItem) ([]error, error) {
+	errs := make([]error, len(items))
+	if c.Binary {
+		fields := make([][]byte, 0, len(items)*4)
+		for _, i := range items {
+			fields = append(fields, []byte(i.Bulk), []byte(i.Key), i.Value, IntField(int64(i.Expire/time.Millisecond)))
+		}
+		f, err := c.Call(ctx, OpMSet, fields...)
+		if err != nil {
+			return nil, err
+		}
+		if f.Code != StatusOK || len(f.Fields) != len(items) {
+			return nil, ErrCommandFailed
+		}
+		for n, s := range f.Fields {
+			if len(s) != 1 || s[0] != StatusOK {
+				errs[n] = ErrCommandFailed
+			}
+		}
+		return errs, nil
+	}
+	params := make([]string, 0, len(items)*4)
+	for _, i := range items {
+		if strings.ContainsAny(string(i.Value), "\t\n") {
+			return nil, ErrInvalidValue
+		}
+		params = append(params, i.Bulk, i.Key, string(i.Value), strconv.Itoa(int(i.Expire/time.Second)))
+	}
+	r, err := c.Do(ctx, MSet, params...)
+	if err != nil {
+		return nil, err
+	}
+	results := strings.Split(r, "\t")
+	if len(results) != len(items) {
+		return nil, ErrCommandFailed
+	}
+	for n, s := range results {
+		if s != Success {
+			errs[n] = ErrCommandFailed
+		}
+	}
+	return errs, nil
+}
+
+// MGet returns alive values of each bulk in one request
+func (c *DageClient) MGet(ctx context.Context, bulks ...string) ([][][]byte, error) {
+	values := make([][][]byte, len(bulks))
+	if c.Binary {
+		fields := make([][]byte, len(bulks))
+		for n, b := range bulks {
+			fields[n] = []byte(b)
+		}
+		f, err := c.Call(ctx, OpMGet, fields...)
+		if err != nil {
+			return nil, err
+		}
+		if f.Code != StatusOK {
+			return nil, ErrCommandFailed
+		}
+		p := 0
+		for n := range bulks {
+			count, err := f.Int(p)
+			if err != nil || p+1+int(count) > len(f.Fields) {
+				return nil, ErrCommandFailed
+			}
+			values[n] = [][]byte{}
+			if count > 0 {
+				values[n] = f.Fields[p+1 : p+1+int(count)]
+				p += int(count)
+			}
+			p++
+		}
+		return values, nil
+	}
+	r, err := c.Do(ctx, MGet, bulks...)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Split(r, "\t\t")
+	p := 0
+	for n := range bulks {
+		if p >= len(fields) {
+			return nil, ErrCommandFailed
+		}
+		count, err := strconv.Atoi(fields[p])
+		if err != nil || p+1+count > len(fields) {
+			return nil, ErrCommandFailed
+		}
+		values[n] = [][]byte{}
+		for i := 0; i < count; i++ {
+			values[n] = append(values[n], []byte(fields[p+1+i]))
+		}
+		if count > 0 {
+			p += count
+		}
+		p++
+	}
+	return values, nil
+}
+
+func (c *DageClient) MRemove(ctx context.Context, bulks ...string) error {
+	if c.Binary {
+		fields := make([][]byte, len(bulks))
+		for n, b := range bulks {
+			fields[n] = []byte(b)
+		}
+		return c.call(ctx, OpMRemove, fields...)
+	}
+	r, err := c.Do(ctx, MRemove, bulks...)
+	if err != nil {
+		return err
+	}
+	return textStatus(r)
+}
+
+// CreateBulk creates bulk by cfg or changes its config,
+// a config rejected by server returns the validation error of server
+func (c *DageClient) CreateBulk(ctx context.Context, bulk string, cfg *BulkConfig) error {
+	max, eliminate := int64(cfg.MaxItem), int64(cfg.Eliminate/time.Millisecond)
+	if c.Binary {
+		f, err := c.Call(ctx, OpCreate, []byte(bulk), IntField(max), IntField(eliminate), []byte(cfg.EvictionPolicy.String()), IntField(int64(cfg.Shards)))
+		if err != nil {
+			return err
+		}
+		switch f.Code {
+		case StatusOK:
+			return nil
+		case StatusDenied:
+			return ErrDenied
+		case StatusFailure:
+			if len(f.Fields) == 1 {
+				return errors.New(string(f.Fields[0]))
+			}
+		}
+		return ErrCommandFailed
+	}
+	r, err := c.Do(ctx, Create, bulk, strconv.FormatInt(max, 10), strconv.FormatInt(eliminate, 10), cfg.EvictionPolicy.String(), strconv.Itoa(cfg.Shards))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(r, Failure+" ") {
+		return errors.New(strings.TrimPrefix(r, Failure+" "))
+	}
+	return textStatus(r)
+}
+
+// SlowLog returns at most n slow commands of server newest first
+func (c *DageClient) SlowLog(ctx context.Context, n int) ([]SlowEntry, error) {
+	entries := []SlowEntry{}
+	if c.Binary {
+		f, err := c.Call(ctx, OpSlowLog, []byte(SlowGet), IntField(int64(n)))
+		if err != nil {
+			return nil, err
+		}
+		if f.Code == StatusDenied {
+			return nil, ErrDenied
+		}
+		if f.Code != StatusOK || len(f.Fields)%7 != 0 {
+			return nil, ErrCommandFailed
+		}
+		for p := 0; p < len(f.Fields); p += 7 {
+			ns := make([]int64, 4)
+			for i, field := range []int{p, p + 1, p + 4, p + 5} {
+				var err error
+				if ns[i], err = f.Int(field); err != nil {
+					return nil, ErrCommandFailed
+				}
+			}
+			entries = append(entries, SlowEntry{
+				ID:       uint64(ns[0]),
+				Time:     unixMilli(ns[1]),
+				Command:  string(f.Fields[p+2]),
+				Bulk:     string(f.Fields[p+3]),
+				Size:     int(ns[2]),
+				Duration: time.Duration(ns[3]) * time.Microsecond,
+				Client:   string(f.Fields[p+6]),
+			})
+		}
+		return entries, nil
+	}
+	r, err := c.Do(ctx, SlowCmd, SlowGet, strconv.Itoa(n))
+	if err != nil {
+		return nil, err
+	}
+	if r == Denied || r == Failure {
+		return nil, textStatus(r)
+	}
+	if r == "" {
+		return entries, nil
+	}
+	//an entry has 7 fields, entries are separated by an empty field
+	fs := strings.Split(r, "\t")
+	for len(fs) >= 7 {
+		ns := make([]int64, 4)
+		for i, field := range []int{0, 1, 4, 5} {
+			var err error
+			if ns[i], err = strconv.ParseInt(fs[field], 10, 64); err != nil {
+				return nil, ErrCommandFailed
+			}
+		}
+		entries = append(entries, SlowEntry{
+			ID:       uint64(ns[0]),
+			Time:     unixMilli(ns[1]),
+			Command:  fs[2],
+			Bulk:     fs[3],
+			Size:     int(ns[2]),
+			Duration: time.Duration(ns[3]) * time.Microsecond,
+			Client:   fs[6],
+		})
+		fs = fs[7:]
+		if len(fs) > 0 {
+			fs = fs[1:]
+		}
+	}
+	return entries, nil
+}
+
+// Do sends a text command with a new tick and returns the response without tick
+func (c *DageClient) Do(ctx context.Context, cmd string, params ...string) (string, error) {
+	if c.Binary {
+		return "", ErrTextProtocol
+	}
+	f, err := c.roundtrip(ctx, func(tick uint64) []byte {
+		return []byte(strings.Join(append([]string{strconv.FormatUint(tick, 10), cmd}, params...), "\t") + "\n")
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(f.Fields[0]), nil
+}
+
+// Call sends a binary request of opcode with a new tick and returns the response frame
+func (c *DageClient) Call(ctx context.Context, op byte, fields ...[]byte) (*Frame, error) {
+	if !c.Binary {
+		return nil, ErrBinaryProtocol
+	}
+	return c.roundtrip(ctx, func(tick uint64) []byte {
+		return NewFrame(op, tick, fields...).Bytes()
+	})
+}
+
+// call sends a binary request whose response carries only a status
+func (c *DageClient) call(ctx context.Context, op byte, fields ...[]byte) error {
+	f, err := c.Call(ctx, op, fields...)
+	if err != nil {
+		return err
+	}
+	if f.Code == StatusDenied {
+		return ErrDenied
+	}
+	if f.Code != StatusOK {
+		return ErrCommandFailed
+	}
+	return nil
+}
+
+// roundtrip sends the request encoded for a new tick and waits its response
+func (c *DageClient) roundtrip(ctx context.Context, encode func(uint64) []byte) (*Frame, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	tick := atomic.AddUint64(&c.tick, 1)
+	req := encode(tick)
+	for {
+		dc, err := c.conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ch, err := dc.send(tick, req)
+		if err == ErrConnBroken {
+			//request is not sent, try another connection
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case f, ok := <-ch:
+			if !ok {
+				return nil, ErrConnBroken
+			}
+			return f, nil
+		case <-ctx.Done():
+			dc.forget(tick)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// conn returns a connection of pool in turn, broken connections are dialed again
+func (c *DageClient) conn(ctx context.Context) (*dageConn, error) {
+	c.Mut.Lock()
+	if c.closed {
+		c.Mut.Unlock()
+		return nil, ErrClientClosed
+	}
+	if c.conns == nil {
+		c.conns = make([]*dageConn, c.MaxConns)
+	}
+	i := int(c.next) % len(c.conns)
+	c.next++
+	dc := c.conns[i]
+	c.Mut.Unlock()
+	if dc != nil && !dc.broken() {
+		return dc, nil
+	}
+
+	dc, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.Mut.Lock()
+	defer c.Mut.Unlock()
+	if c.closed {
+		dc.close(ErrClientClosed)
+		return nil, ErrClientClosed
+	}
+	//another goroutine may reconnect the slot first
+	if old := c.conns[i]; old != nil && !old.broken() {
+		dc.close(ErrConnBroken)
+		return old, nil
+	}
+	c.conns[i] = dc
+	return dc, nil
+}
+
+// dial retries with exponential backoff until ctx is done
+func (c *DageClient) dial(ctx context.Context) (*dageConn, error) {
+	d := &net.Dialer{}
+	backoff := c.MinBackoff
+	for {
+		conn, err := d.DialContext(ctx, "tcp", c.Addr)
+		if err == nil && c.TLS != nil {
+			conn, err = c.secure(ctx, conn)
+		}
+		if err == nil && c.Binary {
+			err = c.negotiate(ctx, conn)
+		}
+		if err == nil {
+			dc := newDageConn(conn, c.Binary)
+			if err = c.login(ctx, dc); err == nil {
+				err = c.selectContainer(ctx, dc)
+			}
+			if err == nil {
+				return dc, nil
+			}
+			dc.close(err)
+			if err == ErrUnauthorized || err == ErrContainerNotFound {
+				//retry does not help a wrong token or container
+				return nil, err
+			}
+		}
+		c.Log.Warning(fmt.Sprintf("Dial dage server %s error[%s], retry after %s", c.Addr, err.Error(), backoff.String()))
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+// secure runs TLS handshake over conn
+func (c *DageClient) secure(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	cfg := c.TLS
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		if host, _, err := net.SplitHostPort(c.Addr); err == nil {
+			cfg.ServerName = host
+		} else {
+			cfg.ServerName = c.Addr
+		}
+	}
+	tc := tls.Client(conn, cfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		tc.SetDeadline(deadline)
+		defer tc.SetDeadline(time.Time{})
+	}
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// login sends AUTH with c.Token on a new connection before it joins the pool
+func (c *DageClient) login(ctx context.Context, dc *dageConn) error {
+	if c.Token == "" {
+		return nil
+	}
+	ok, err := c.exchange(ctx, dc, Login, OpAuth, c.Token)
+	if err == nil && !ok {
+		err = ErrUnauthorized
+	}
+	return err
+}
+
+// selectContainer sends SELECT with c.Container on a new connection before it joins the pool
+func (c *DageClient) selectContainer(ctx context.Context, dc *dageConn) error {
+	if c.Container == "" {
+		return nil
+	}
+	ok, err := c.exchange(ctx, dc, Select, OpSelect, c.Container)
+	if err == nil && !ok {
+		err = ErrContainerNotFound
+	}
+	return err
+}
+
+// exchange sends a command of a single param on dc and tells whether it succeeds
+func (c *DageClient) exchange(ctx context.Context, dc *dageConn, cmd string, op byte, param string) (bool, error) {
+	tick := atomic.AddUint64(&c.tick, 1)
+	req := []byte(strings.Join([]string{strconv.FormatUint(tick, 10), cmd, param}, "\t") + "\n")
+	if dc.binary {
+		req = NewFrame(op, tick, []byte(param)).Bytes()
+	}
+	ch, err := dc.send(tick, req)
+	if err != nil {
+		return false, err
+	}
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return false, ErrConnBroken
+		}
+		return dc.binary && f.Code == StatusOK || !dc.binary && string(f.Fields[0]) == Success, nil
+	case <-ctx.Done():
+		dc.forget(tick)
+		return false, ctx.Err()
+	}
+}
+
+// negotiate switches conn to binary protocol
+func (c *DageClient) negotiate(ctx context.Context, conn net.Conn) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	_, err := conn.Write(handshake(BinaryVersion))
+	if err == nil {
+		var version byte
+		if version, err = readHandshake(conn); err == nil && version != BinaryVersion {
+			err = ErrHandshake
+		}
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return err
+}
+
+func (c *DageClient) Close() error {
+	c.Mut.Lock()
+	defer c.Mut.Unlock()
+	c.closed = true
+	for _, dc := range c.conns {
+		if dc != nil {
+			dc.close(ErrClientClosed)
+		}
+	}
+	return nil
+}
+
+func newDageConn(conn net.Conn, binary bool) *dageConn {
+	dc := &dageConn{
+		Mut:     &sync.Mutex{},
+		conn:    conn,
+		binary:  binary,
+		pending: map[uint64]chan *Frame{},
+	}
+	go dc.read()
+	return dc
+}
+
+func (dc *dageConn) send(tick uint64, req []byte) (chan *Frame, error) {
+	ch := make(chan *Frame, 1)
+	dc.Mut.Lock()
+	defer dc.Mut.Unlock()
+	if dc.err != nil {
+		return nil, ErrConnBroken
+	}
+	dc.pending[tick] = ch
+	if _, err := dc.conn.Write(req); err != nil {
+		delete(dc.pending, tick)
+		dc.fail(err)
+		return nil, ErrConnBroken
+	}
+	return ch, nil
+}
+
+// read dispatches responses to pending requests by tick
+func (dc *dageConn) read() {
+	r := bufio.NewReader(dc.conn)
+	for {
+		f, err := dc.receive(r)
+		if err != nil {
+			dc.Mut.Lock()
+			dc.fail(err)
+			dc.Mut.Unlock()
+			return
+		}
+		dc.Mut.Lock()
+		if ch, ok := dc.pending[f.Tick]; ok {
+			ch <- f
+			delete(dc.pending, f.Tick)
+		}
+		dc.Mut.Unlock()
+	}
+}
+
+// receive reads a response, a text response is a frame holding the line without tick
+func (dc *dageConn) receive(r *bufio.Reader) (*Frame, error) {
+	if dc.binary {
+		return ReadFrame(r)
+	}
+	l, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	tick, resp := parseResponse(l)
+	//lines without a numeric tick match no request
+	t, _ := strconv.ParseUint(tick, 10, 64)
+	return NewFrame(StatusOK, t, []byte(resp)), nil
+}
+
+// textStatus maps a text response of Success or Failure to an error
+func textStatus(r string) error {
+	switch r {
+	case Success:
+		return nil
+	case Denied:
+		return ErrDenied
+	}
+	return ErrCommandFailed
+}
+
+// parseResponse splits "tick response \n" written by Dage.Command
+func parseResponse(l string) (string, string) {
+	l = strings.TrimSuffix(l, "\n")
+	l = strings.TrimSuffix(l, " ")
+	i := strings.Index(l, " ")
+	if i < 0 {
+		return l, ""
+	}
+	return l[:i], l[i+1:]
+}
+
+func (dc *dageConn) forget(tick uint64) {
+	dc.Mut.Lock()
+	defer dc.Mut.Unlock()
+	delete(dc.pending, tick)
+}
+
+func (dc *dageConn) broken() bool {
+	dc.Mut.Lock()
+	defer dc.Mut.Unlock()
+	return dc.err != nil
+}
+
+func (dc *dageConn) close(err error) {
+	dc.Mut.Lock()
+	defer dc.Mut.Unlock()
+	dc.fail(err)
+}
+
+// fail closes connection and pending requests, caller must hold the lock
+func (dc *dageConn) fail(err error) {
+	if dc.err != nil {
+		return
+	}
+	dc.err = err
+	dc.conn.Close()
+	for tick, ch := range dc.pending {
+		close(ch)
+		delete(dc.pending, tick)
+	}
+}
@@ -0,0 +1,212 @@
+package bulkCache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDage listens a Dage server of a new container
+func newTestDage() *Dage {
+	db, _ := NewContainer("", "")
+	d := NewDage(db)
+	d.Listen("127.0.0.1:0")
+	return d
+}
+
+func Test_DageClient(t *testing.T) {
+	testDageClient(t, true)
+	testDageClient(t, false)
+}
+
+func testDageClient(t *testing.T, binary bool) {
+	d := newTestDage()
+	defer d.Listener.Close()
+	c := NewDageClient()
+	c.Addr = d.Listener.Addr().String()
+	c.Binary = binary
+	defer c.Close()
+	ctx := context.Background()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := c.Set(ctx, "Client Video", fmt.Sprint(i), []byte(fmt.Sprintf("Tag %d", i)), time.Minute); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	values, err := c.Get(ctx, "Client Video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 20 {
+		t.Errorf("get %d values, want 20", len(values))
+	}
+	vs := []string{}
+	for _, v := range values {
+		vs = append(vs, string(v))
+	}
+	sort.Strings(vs)
+	if vs[0] != "Tag 0" || vs[19] != "Tag 9" {
+		t.Errorf("get values %v", vs)
+	}
+	if err := c.Set(ctx, "Client Video", "bad", []byte("a\tb"), time.Minute); binary == (err == ErrInvalidValue) {
+		t.Errorf("value with tab over binary protocol %v error[%v]", binary, err)
+	}
+	c.Remove(ctx, "Client Video")
+
+	errs, err := c.MSet(ctx, []BatchItem{
+		{Bulk: "Client Audio", Key: "a", Value: []byte("Audio a"), Expire: time.Minute},
+		{Bulk: "Client Image", Key: "a", Value: []byte("Image a"), Expire: time.Minute},
+		{Bulk: "Client Audio", Key: "b", Value: []byte("Audio b"), Expire: time.Minute},
+	})
+	if err != nil || len(errs) != 3 || errs[0] != nil || errs[1] != nil || errs[2] != nil {
+		t.Fatalf("mset get %v error[%v]", errs, err)
+	}
+	batch, err := c.MGet(ctx, "Client Audio", "Not Found", "Client Image")
+	if err != nil || len(batch) != 3 || len(batch[0]) != 2 || len(batch[1]) != 0 || string(batch[2][0]) != "Image a" {
+		t.Errorf("mget get %q error[%v]", batch, err)
+	}
+	//an empty value is told from a missing bulk
+	c.Set(ctx, "Client Empty", "a", []byte{}, time.Minute)
+	batch, err = c.MGet(ctx, "Client Empty", "Not Found")
+	if err != nil || len(batch) != 2 || len(batch[0]) != 1 || len(batch[0][0]) != 0 || len(batch[1]) != 0 {
+		t.Errorf("mget get %q error[%v]", batch, err)
+	}
+	c.Remove(ctx, "Client Empty")
+	if err := c.MRemove(ctx, "Client Audio", "Client Image"); err != nil {
+		t.Error(err)
+	}
+	if db := d.Containers.Default(); db.Has("Client Audio") || db.Has("Client Image") {
+		t.Error("mremove should remove every bulk")
+	}
+
+	if err := c.Remove(ctx, "Client Video"); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := c.Get(ctx, "Client Video"); len(values) != 0 {
+		t.Errorf("removed bulk has %d values", len(values))
+	}
+
+	//server drops every connection, client dials again
+	d.Mut.Lock()
+	for _, cli := range d.Clients {
+		cli.Conn.Close()
+	}
+	d.Mut.Unlock()
+	time.Sleep(time.Millisecond * 50)
+	for i := 0; i < c.MaxConns; i++ {
+		if err := c.Ping(ctx); err != nil {
+			t.Errorf("ping after reconnect error[%s]", err.Error())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if binary {
+		if f, err := c.Call(ctx, 0xff); err != nil || f.Code != StatusUnknown {
+			t.Errorf("unknown opcode get %v error[%v]", f, err)
+		}
+	} else if _, err := c.Do(ctx, "UNKNOWN"); err != nil {
+		t.Errorf("unknown command should get a response, error[%s]", err.Error())
+	}
+}
+
+func Test_DageClientBackoff(t *testing.T) {
+	d := newTestDage()
+	addr := d.Listener.Addr().String()
+	d.Listener.Close()
+
+	c := NewDageClient()
+	c.Addr = addr
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	start := time.Now()
+	if err := c.Ping(ctx); err == nil {
+		t.Fatal("ping closed server should fail")
+	}
+	if time.Since(start) < time.Millisecond*250 {
+		t.Error("client should retry until context deadline")
+	}
+}
+
+func Test_DagePipeline(t *testing.T) {
+	db, _ := NewContainer("", "")
+	d := NewDage(db)
+	d.MaxInFlight = 2
+	d.Listen("127.0.0.1:0")
+	defer d.Listener.Close()
+	conn, err := net.Dial("tcp", d.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	//server stops reading while 2 commands are in flight, writer must not block reader
+	go func() {
+		w := bufio.NewWriter(conn)
+		for i := 0; i < 200; i++ {
+			if i%2 == 0 {
+				fmt.Fprintf(w, "%d\tSET\tPipeline Video\t%d\tTag %d\t60\n", i, i, i)
+			} else {
+				fmt.Fprintf(w, "%d\tGET\tPipeline Video\n", i)
+			}
+		}
+		fmt.Fprint(w, "200\tQUIT\n")
+		w.Flush()
+	}()
+
+	r := bufio.NewReader(conn)
+	ticks := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		tick, _ := parseResponse(l)
+		if ticks[tick] {
+			t.Errorf("tick %s answered twice", tick)
+		}
+		ticks[tick] = true
+	}
+	if len(ticks) != 200 {
+		t.Errorf("get %d ticks, want 200", len(ticks))
+	}
+	if l, _ := r.ReadString('\n'); l != "Good luck!\n" {
+		t.Errorf("quit is not answered last, get %q", l)
+	}
+}
+
+func Test_DageServers(t *testing.T) {
+	a, b := newTestDage(), newTestDage()
+	defer a.Listener.Close()
+	defer b.Listener.Close()
+	ctx := context.Background()
+	c := NewDageClient()
+	c.Addr = a.Listener.Addr().String()
+	defer c.Close()
+	if err := c.Set(ctx, "Servers Video", "k", []byte("a"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Containers.Default().Has("Servers Video") || b.Containers.Default().Has("Servers Video") {
+		t.Error("servers should not share containers")
+	}
+	if a.SlowLog == b.SlowLog || NewRespServer(nil).SlowLog == a.SlowLog {
+		t.Error("servers should not share slow logs")
+	}
+	if a.SlowLog == DefaultSlowLog || DageApi.SlowLog != DefaultSlowLog || RespApi.SlowLog != DefaultSlowLog {
+		t.Error("only package servers share the default slow log")
+	}
+}
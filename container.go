@@ -2,7 +2,10 @@ package bulkCache
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,14 +13,23 @@ import (
 )
 
 var (
+	// Default is the container of DageApi, HttpApi and RespApi, kept for compatibility
+	// and set up by Defaults, servers created by NewDage, NewEchoHttpServer and
+	// NewRespServer use their own
 	Default *Container
+
+	ErrBulkNotFound = errors.New("Bulk is not found")
+	ErrItemNotFound = errors.New("Item is not found")
+	ErrBulkFull     = errors.New("Bulk is fulled")
 )
 
 const (
 	KeySize = 32
 
-	HashEngine  = "hash"
-	BTreeEngine = "btree"
+	HashEngine    = "hash"
+	BTreeEngine   = "btree"
+	WheelEngine   = "wheel"
+	ShardedEngine = "sharded"
 )
 
 type (
@@ -25,8 +37,15 @@ type (
 
 	EachHandler func(Bulk)
 
+	// RangeHandler handles an item of a range, returns false to stop
+	RangeHandler func(string, *Item) bool
+
 	Bulk interface {
 		Add(string, []byte, time.Duration) error
+		Get(string) *Item
+		Update(string, []byte, time.Duration) error
+		Delete(string) bool
+		Evict(EvictionPolicy) (int, bool) //bytes freed, false if nothing is evicted
 		GetAlive() Cached
 		GetAliveInBulk() Bulk
 		Config() *BulkConfig
@@ -37,27 +56,76 @@ type (
 		Analytics() *Analytics
 	}
 
+	// DeadlineBulk adds and updates items by the expire time of caller,
+	// so the container logs the same deadline the bulk stores
+	DeadlineBulk interface {
+		Bulk
+		AddAt(key string, value []byte, expire time.Time) error
+		UpdateAt(key string, value []byte, expire time.Time) error
+	}
+
+	// EliminateBulk removes its expired items in a goroutine running Eliminate
+	// until Stop, the container starts it when the bulk is attached
+	EliminateBulk interface {
+		Bulk
+		Eliminate()
+	}
+
+	// RangeBulk is a bulk ordered by expire
+	RangeBulk interface {
+		Bulk
+		Range(from, to time.Time, limit int, handler RangeHandler)
+	}
+
 	BulkConfig struct {
-		MaxItem      int
-		Eliminate    time.Duration
-		EnabledCache bool
+		MaxItem        int //0 or negative means unlimited
+		Eliminate      time.Duration
+		EnabledCache   bool
+		EvictionPolicy EvictionPolicy
+		Shards         int //shards of sharded engine
 	}
 
 	Container struct {
 		Mut       *sync.RWMutex
 		Analytics *Analytics
 		bulks     map[string]Bulk
+		configs   map[string]*BulkConfig //set by SetBulkConfig
+		templates []*BulkTemplate
 		Log       *log.Entry
 		Name      string
 		Engine    string
+		factory   EngineFactory
+		aofMut    *sync.RWMutex //guards appendLog, bulks call back under their locks
+		appendLog *AppendLog
+		wheel     *TimingWheel
+		subs      *subscriptions
+		done      chan struct{} //closed by Close
+		running   bool          //master is started by the first bulk attached
+
+		maxMemory    int64
+		memoryPolicy MemoryPolicy
+		memorySignal chan struct{}
 	}
 
+	KeyItem struct {
+		Key string
+		*Item
+	}
+
+	byExpire []KeyItem
+
 	Item struct {
 		Data   []byte
 		Expire time.Time
+		access int64 //unix nano of last access
+		hits   int64
 	}
 )
 
+func NewItem(data []byte, expire time.Time) *Item {
+	return &Item{Data: data, Expire: expire, access: time.Now().UnixNano()}
+}
+
 func GenerateKey() (string, error) {
 	b := make([]byte, KeySize)
 	_, err := rand.Read(b)
@@ -67,45 +135,76 @@ func GenerateKey() (string, error) {
 	return string(b), nil
 }
 
-func NewContainer(name string, engine string) *Container {
+// PaddingKey cuts or pads sub key to KeySize bytes
+func PaddingKey(sub string) string {
+	if len(sub) > KeySize {
+		return sub[:KeySize]
+	}
+	if len(sub) < KeySize {
+		return sub + string(make([]byte, KeySize-len(sub)))
+	}
+	return sub
+}
+
+// TrimKey strips the zero padding of sub key
+func TrimKey(sub string) string {
+	return strings.TrimRight(sub, "\x00")
+}
+
+func NewContainer(name string, engine string) (*Container, error) {
 	if name == "" {
 		name = "Default"
 	}
 	if engine == "" {
 		engine = BTreeEngine
 	}
+	factory, ok := GetEngine(engine)
+	if !ok {
+		return nil, fmt.Errorf("Unknown engine %s", engine)
+	}
 	c := &Container{
 		Mut:       new(sync.RWMutex),
-		Analytics: &Analytics{},
+		aofMut:    new(sync.RWMutex),
+		Analytics: NewAnalytics(),
 		Name:      name,
 		Engine:    engine,
+		factory:   factory,
 		bulks:     make(map[string]Bulk),
+		configs:   make(map[string]*BulkConfig),
+		subs:      newSubscriptions(),
+		done:      make(chan struct{}),
 		Log: log.WithFields(log.Fields{
 			"Store Engine": fmt.Sprintf("%s Container", name),
 		}),
 	}
-	go c.master()
-	return c
+	return c, nil
 }
 
 func (c *Container) NewBulk(cfg *BulkConfig) Bulk {
-	switch c.Engine {
-	case HashEngine:
-		return NewHashBulk(cfg)
-	case BTreeEngine:
-		return NewBTreeBulk(cfg)
-	}
-	return NewBTreeBulk(cfg)
+	return c.factory(cfg, nil)
 }
 
 func (c *Container) NewBulkFromCached(cfg *BulkConfig, cached Cached) Bulk {
-	switch c.Engine {
-	case HashEngine:
-		return NewHashBulkFromCached(cfg, cached)
-	case BTreeEngine:
-		return NewBTreeBulkFromCached(cfg, cached)
+	if cached == nil {
+		cached = Cached{}
 	}
-	return NewBTreeBulkFromCached(cfg, cached)
+	return c.factory(cfg, cached)
+}
+
+// addAt adds an item expiring at expire, a bulk without DeadlineBulk gets the ttl left
+func addAt(b Bulk, sub string, value []byte, expire time.Time) error {
+	if db, ok := b.(DeadlineBulk); ok {
+		return db.AddAt(sub, value, expire)
+	}
+	return b.Add(sub, value, time.Until(expire))
+}
+
+// updateAt overwrites an item by one expiring at expire, see addAt
+func updateAt(b Bulk, sub string, value []byte, expire time.Time) error {
+	if db, ok := b.(DeadlineBulk); ok {
+		return db.UpdateAt(sub, value, expire)
+	}
+	return b.Update(sub, value, time.Until(expire))
 }
 
 func (c *Container) GetBulk(key string) (Bulk, bool) {
@@ -115,16 +214,65 @@ func (c *Container) GetBulk(key string) (Bulk, bool) {
 	return bulk, ok
 }
 
+// observe records latency of operation started at start
+func (c *Container) observe(op string, start time.Time) {
+	c.Analytics.Observe(op, time.Since(start))
+}
+
 func (c *Container) Get(key string) (Cached, bool) {
-	defer c.Analytics.Get()
+	defer c.observe(LatencyGet, time.Now())
 	b, ok := c.GetBulk(key)
 	if !ok {
 		c.Log.Warning(fmt.Sprintf("Bulk %s is empty", key))
+		c.Analytics.Miss()
 		return nil, false
 	}
-	return b.GetAlive(), true
+	c.Analytics.Get()
+	b.Analytics().Get()
+	cached := b.GetAlive()
+	if len(cached) == 0 {
+		c.Analytics.Miss()
+		b.Analytics().Miss()
+	} else {
+		c.Analytics.Hit()
+		b.Analytics().Hit()
+	}
+	return cached, true
 }
 
+// Range returns alive items of bulk expiring in [from, to] ordered by expire,
+// bulk not ordered by expire is sorted in memory
+func (c *Container) Range(key string, from, to time.Time, limit int) ([]KeyItem, error) {
+	b, ok := c.GetBulk(key)
+	if !ok {
+		return nil, ErrBulkNotFound
+	}
+	c.Analytics.Get()
+	b.Analytics().Get()
+	its := []KeyItem{}
+	if rb, ok := b.(RangeBulk); ok {
+		rb.Range(from, to, limit, func(sub string, i *Item) bool {
+			its = append(its, KeyItem{Key: sub, Item: i})
+			return true
+		})
+		return its, nil
+	}
+	for sub, i := range b.GetAlive() {
+		if !i.Expire.Before(from) && !i.Expire.After(to) {
+			its = append(its, KeyItem{Key: sub, Item: i})
+		}
+	}
+	sort.Sort(byExpire(its))
+	if limit > 0 && len(its) > limit {
+		its = its[:limit]
+	}
+	return its, nil
+}
+
+func (s byExpire) Len() int           { return len(s) }
+func (s byExpire) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byExpire) Less(i, j int) bool { return s[i].Expire.Before(s[j].Expire) }
+
 func (c *Container) GetBulkItems(key string) (bulk Bulk, ok bool) {
 	b, ok := c.GetBulk(key)
 	if !ok {
@@ -137,19 +285,59 @@ func (c *Container) GetBulkItems(key string) (bulk Bulk, ok bool) {
 	return c.NewBulkFromCached(b.Config(), its), true
 }
 
+// AddBulk returns bulk of key, a new bulk is created by cfg,
+// or the config set by SetBulkConfig or of a template if cfg is nil
 func (c *Container) AddBulk(key string, cfg *BulkConfig) Bulk {
 	c.Mut.Lock()
 	defer c.Mut.Unlock()
 	b, ok := c.bulks[key]
 	if !ok {
-		b = c.NewBulk(cfg)
-		c.bulks[key] = b
+		b = c.createBulk(key, cfg, nil)
+		c.attach(key, b)
 	}
 	return b
 }
 
+// attach puts b to bulks and starts its expiration, by the timing wheel
+// shared by the container or its own Eliminate, caller must hold the lock
+func (c *Container) attach(key string, b Bulk) {
+	if eb, ok := b.(EventBulk); ok {
+		eb.OnEvent(func(t EventType, sub string, i *Item) {
+			c.publishItem(t, key, sub, i)
+			if t != EventEvict {
+				return
+			}
+			//evicted items must not come back by replay
+			if l := c.aof(); l != nil {
+				if err := l.Delete(key, sub); err != nil {
+					c.Log.Error(fmt.Sprintf("Append Evict to log error[%s]", err.Error()))
+				}
+			}
+		})
+	}
+	if !c.running {
+		c.running = true
+		go c.master()
+	}
+	if sb, ok := b.(ScheduledBulk); ok {
+		if c.wheel == nil {
+			c.wheel = NewDefaultTimingWheel()
+			go c.wheel.Run()
+		}
+		sb.SetScheduler(c.wheel)
+	} else if eb, ok := b.(EliminateBulk); ok {
+		go eb.Eliminate()
+	}
+	if old, ok := c.bulks[key]; ok {
+		old.Stop()
+		old.Analytics().detach()
+	}
+	b.Analytics().attach(c.Analytics)
+	c.bulks[key] = b
+}
+
 func (c *Container) Add(key, sub string, value []byte, expire time.Duration) error {
-	defer c.Analytics.Add(value)
+	defer c.observe(LatencyAdd, time.Now())
 	var bulk Bulk
 	if !c.Has(key) {
 		bulk = c.AddBulk(key, nil)
@@ -166,13 +354,79 @@ func (c *Container) Add(key, sub string, value []byte, expire time.Duration) err
 			return err
 		}
 	}
-	if len(sub) > KeySize {
-		sub = sub[:KeySize]
+	sub = PaddingKey(sub)
+	deadline := time.Now().Add(expire)
+	if err := addAt(bulk, sub, value, deadline); err != nil {
+		return err
 	}
-	if len(sub) < KeySize {
-		sub = sub + string(make([]byte, KeySize-len(sub)))
+	c.publishItem(EventAdd, key, sub, &Item{Data: value, Expire: deadline})
+	c.checkMemory()
+	if l := c.aof(); l != nil {
+		if err := l.Add(key, sub, value, deadline); err != nil {
+			c.Log.Error(fmt.Sprintf("Append Add to log error[%s]", err.Error()))
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Container) GetItem(key, sub string) (*Item, bool) {
+	defer c.observe(LatencyGetItem, time.Now())
+	b, ok := c.GetBulk(key)
+	if !ok {
+		c.Analytics.Miss()
+		return nil, false
+	}
+	c.Analytics.Get()
+	i := b.Get(PaddingKey(sub))
+	if i == nil {
+		c.Analytics.Miss()
+		b.Analytics().Miss()
+		return nil, false
 	}
-	return bulk.Add(sub, value, expire)
+	c.Analytics.Hit()
+	b.Analytics().Hit()
+	return i, true
+}
+
+// UpdateItem overwrites an alive item, it never creates a new one
+func (c *Container) UpdateItem(key, sub string, value []byte, expire time.Duration) error {
+	b, ok := c.GetBulk(key)
+	if !ok {
+		return ErrBulkNotFound
+	}
+	sub = PaddingKey(sub)
+	deadline := time.Now().Add(expire)
+	if err := updateAt(b, sub, value, deadline); err != nil {
+		return err
+	}
+	c.publishItem(EventAdd, key, sub, &Item{Data: value, Expire: deadline})
+	c.checkMemory()
+	if l := c.aof(); l != nil {
+		if err := l.Add(key, sub, value, deadline); err != nil {
+			c.Log.Error(fmt.Sprintf("Append Update to log error[%s]", err.Error()))
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Container) DeleteItem(key, sub string) bool {
+	b, ok := c.GetBulk(key)
+	if !ok {
+		return false
+	}
+	sub = PaddingKey(sub)
+	if !b.Delete(sub) {
+		return false
+	}
+	c.publishItem(EventRemove, key, sub, nil)
+	if l := c.aof(); l != nil {
+		if err := l.Delete(key, sub); err != nil {
+			c.Log.Error(fmt.Sprintf("Append Delete to log error[%s]", err.Error()))
+		}
+	}
+	return true
 }
 
 func (c *Container) Has(key string) bool {
@@ -183,19 +437,126 @@ func (c *Container) Has(key string) bool {
 }
 
 func (c *Container) Remove(key string) {
-	bulk, ok := c.GetBulk(key)
-	if ok {
-		bulk.Stop()
+	c.removeBulk(key)
+	c.Mut.Lock()
+	delete(c.configs, key)
+	c.Mut.Unlock()
+	c.publishItem(EventRemove, key, "", nil)
+	if l := c.aof(); l != nil {
+		if err := l.Remove(key); err != nil {
+			c.Log.Error(fmt.Sprintf("Append Remove to log error[%s]", err.Error()))
+		}
 	}
+}
+
+func (c *Container) removeBulk(key string) {
 	c.Mut.Lock()
 	defer c.Mut.Unlock()
+	if bulk, ok := c.bulks[key]; ok {
+		bulk.Stop()
+		bulk.Analytics().detach()
+	}
 	delete(c.bulks, key)
 }
 
 func (c *Container) Flush() {
+	for key := range c.copyBulks() {
+		c.publishItem(EventRemove, key, "", nil)
+	}
+	c.flush()
+	if l := c.aof(); l != nil {
+		if err := l.Flush(); err != nil {
+			c.Log.Error(fmt.Sprintf("Append Flush to log error[%s]", err.Error()))
+		}
+	}
+}
+
+func (c *Container) flush() {
 	c.Mut.Lock()
 	defer c.Mut.Unlock()
+	for _, b := range c.bulks {
+		b.Stop()
+		b.Analytics().detach()
+	}
 	c.bulks = map[string]Bulk{}
+	c.configs = map[string]*BulkConfig{}
+}
+
+// OpenAppendLog replays the log of path and records every write after it
+func (c *Container) OpenAppendLog(path string, policy SyncPolicy) error {
+	if err := c.ReplayAppendLog(path); err != nil {
+		return err
+	}
+	l, err := NewAppendLog(path, policy)
+	if err != nil {
+		return err
+	}
+	c.aofMut.Lock()
+	c.appendLog = l
+	c.aofMut.Unlock()
+	return nil
+}
+
+// aof returns the append log, nil if it is not open
+func (c *Container) aof() *AppendLog {
+	c.aofMut.RLock()
+	defer c.aofMut.RUnlock()
+	return c.appendLog
+}
+
+// RewriteAppendLog compacts the append log from current alive items
+func (c *Container) RewriteAppendLog() error {
+	l := c.aof()
+	if l == nil {
+		return nil
+	}
+	return l.Rewrite(c)
+}
+
+func (c *Container) CloseAppendLog() error {
+	c.aofMut.Lock()
+	l := c.appendLog
+	c.appendLog = nil
+	c.aofMut.Unlock()
+	if l == nil {
+		return nil
+	}
+	return l.Close()
+}
+
+// copyBulks returns a copy of bulks map, so bulks can be walked without container lock
+func (c *Container) copyBulks() map[string]Bulk {
+	c.Mut.RLock()
+	defer c.Mut.RUnlock()
+	bulks := make(map[string]Bulk, len(c.bulks))
+	for k, b := range c.bulks {
+		bulks[k] = b
+	}
+	return bulks
+}
+
+// copyConfigs returns copies of configs set by SetBulkConfig
+func (c *Container) copyConfigs() map[string]*BulkConfig {
+	c.Mut.RLock()
+	defer c.Mut.RUnlock()
+	configs := make(map[string]*BulkConfig, len(c.configs))
+	for k, cfg := range c.configs {
+		cp := *cfg
+		configs[k] = &cp
+	}
+	return configs
+}
+
+// eachAlive calls handler with every alive item until it returns an error
+func (c *Container) eachAlive(handler func(bulk, sub string, i *Item) error) error {
+	for k, b := range c.copyBulks() {
+		for sub, i := range b.GetAlive() {
+			if err := handler(k, sub, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 //just for debug
@@ -209,15 +570,42 @@ func (c *Container) Each(handler EachHandler) {
 
 func (c *Container) master() {
 	for {
-		<-time.After(time.Second * 3)
+		select {
+		case <-c.done:
+			return
+		case <-time.After(time.Second * 3):
+		}
+		c.Mut.RLock()
+		es := []string{}
 		for k, v := range c.bulks {
 			if v.Len() == 0 {
-				c.Remove(k)
+				es = append(es, k)
 			}
 		}
+		c.Mut.RUnlock()
+		for _, k := range es {
+			c.removeBulk(k)
+		}
 	}
 }
 
-func init() {
-	Default = NewContainer("", "")
+// Close stops bulks of c, its timing wheel and background goroutines,
+// then closes the append log. c must not be used after it
+func (c *Container) Close() error {
+	c.Mut.Lock()
+	select {
+	case <-c.done:
+		c.Mut.Unlock()
+		return nil
+	default:
+	}
+	close(c.done)
+	for _, b := range c.bulks {
+		b.Stop()
+	}
+	if c.wheel != nil {
+		c.wheel.Stop()
+	}
+	c.Mut.Unlock()
+	return c.CloseAppendLog()
 }
@@ -2,12 +2,13 @@ package bulkCache
 
 import (
 	"fmt"
+	"runtime"
 	"testing"
 	"time"
 )
 
 func Test_Container(t *testing.T) {
-	c := NewContainer("Default", BTreeEngine)
+	c, _ := NewContainer("Default", BTreeEngine)
 	m := 3  //3 bulk
 	n := 10 //10 item pre bulk
 	for j := 0; j < m; j++ {
@@ -23,3 +24,70 @@ func Test_Container(t *testing.T) {
 		t.Log(bulk.String())
 	})
 }
+
+func Test_ContainerItem(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine} {
+		c, err := NewContainer("Item", engine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Add("Video", "a", []byte("Tag a"), time.Second*10)
+		c.Add("Video", "b", []byte("Tag b"), time.Second*10)
+		i, ok := c.GetItem("Video", "a")
+		if !ok || string(i.Data) != "Tag a" {
+			t.Errorf("[%s] get item a failure", engine)
+		}
+		if err := c.UpdateItem("Video", "a", []byte("Tag aa"), time.Second*10); err != nil {
+			t.Errorf("[%s] update item a error[%s]", engine, err.Error())
+		}
+		if i, _ := c.GetItem("Video", "a"); i == nil || string(i.Data) != "Tag aa" {
+			t.Errorf("[%s] item a is not updated", engine)
+		}
+		if err := c.UpdateItem("Video", "c", []byte("Tag c"), time.Second); err != ErrItemNotFound {
+			t.Errorf("[%s] update missing item should fail", engine)
+		}
+		if !c.DeleteItem("Video", "a") || c.DeleteItem("Video", "a") {
+			t.Errorf("[%s] delete item a failure", engine)
+		}
+		if _, ok := c.GetItem("Video", "a"); ok {
+			t.Errorf("[%s] item a is still alive", engine)
+		}
+		if its, _ := c.Get("Video"); len(its) != 1 {
+			t.Errorf("[%s] bulk should have 1 item, got %d", engine, len(its))
+		}
+	}
+}
+
+func Test_RegisterEngine(t *testing.T) {
+	if _, err := NewContainer("Unknown", "unknown"); err == nil {
+		t.Error("unknown engine should fail")
+	}
+	RegisterEngine("test", func(cfg *BulkConfig, cached Cached) Bulk {
+		return NewHashBulk(cfg)
+	})
+	defer unregisterEngine("test")
+	c, err := NewContainer("Test", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add("Video", "a", []byte("Tag a"), time.Second)
+	if _, ok := c.GetItem("Video", "a"); !ok {
+		t.Error("registered engine get item failure")
+	}
+}
+
+func Test_BulkCopyGoroutines(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine, ShardedEngine} {
+		c, _ := NewContainer("Copies", engine)
+		c.Add("Video", "a", []byte("Tag a"), time.Minute)
+		n := runtime.NumGoroutine()
+		for i := 0; i < 100; i++ {
+			if _, ok := c.GetBulkItems("Video"); !ok {
+				t.Fatalf("[%s] bulk copy is empty", engine)
+			}
+		}
+		if m := runtime.NumGoroutine(); m > n+50 {
+			t.Errorf("[%s] bulk copies leak goroutines, %d => %d", engine, n, m)
+		}
+	}
+}
@@ -2,11 +2,14 @@ package bulkCache
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -18,48 +21,88 @@ const (
 	Set     = "SET"
 	GET     = "GET"
 	Remove  = "REMOVE"
+	MSet    = "MSET"
+	MGet    = "MGET"
+	MRemove = "MREMOVE"
+	Range   = "RANGE"
+	Scan    = "SCAN"
+	Sub     = "SUBSCRIBE"
+	Unsub   = "UNSUBSCRIBE"
+	Emit    = "EVENT"
 	Quit    = "QUIT"
+	Login   = "AUTH"
+	SlowCmd = "SLOWLOG"
+	Create  = "CREATE"
+	Select  = "SELECT"
 	Success = "Success"
 	Failure = "Failure"
+	Denied  = "Denied"
 )
 
 var (
-	DageApi    *Dage
+	// textCommands are commands known to the text protocol, they label metrics
+	textCommands = map[string]struct{}{
+		Ping: {}, Set: {}, GET: {}, Remove: {}, MSet: {}, MGet: {}, MRemove: {},
+		Range: {}, Scan: {}, Sub: {}, Unsub: {}, Quit: {}, Login: {}, SlowCmd: {},
+		Create: {}, Select: {},
+	}
+
+	DageApi    *Dage       //Dage server of Default, set up by Defaults
 	GiveUpTime int64 = 600 //10 minutes
+
+	DefaultMaxInFlight = 64
 )
 
 type (
 	Dage struct {
+		Mut      *sync.Mutex
 		Listener net.Listener
 		Clients  []*Client
 		Log      *log.Entry
+		//commands of a connection processed at once, the connection
+		//is not read while they are all in flight
+		MaxInFlight int
+		//tokens required by AUTH, nil lets everyone in
+		Auth *Auth
+		//listener is wrapped by TLS if not nil
+		TLS *tls.Config
+		//latency of commands by name
+		Latency *LatencyVec
+		//slow commands, nil logs nothing
+		SlowLog *SlowLog
+		//containers selected by SELECT, clients start with the default one
+		Containers *Registry
 	}
 	Client struct {
-		Conn net.Conn
-		Last int64 //unix timestamp
-	}
-
-	DageClient struct {
-		Conn net.Conn
+		Mut      *sync.Mutex //serializes writes of responses and events
+		Conn     net.Conn
+		Last     int64 //unix timestamp
+		subs     []*Subscription
+		inflight chan struct{}
+		wg       *sync.WaitGroup
+		token    *Token
+		db       *Container //selected container, nil is the default one
 	}
 )
 
-func NewDage() *Dage {
+// NewDage creates a Dage server whose clients use c until they SELECT another container
+func NewDage(c *Container) *Dage {
 	return &Dage{
-		Clients: []*Client{},
+		Mut:         &sync.Mutex{},
+		Clients:     []*Client{},
+		MaxInFlight: DefaultMaxInFlight,
+		Latency:     NewLatencyVec(DefaultLatencyBuckets),
+		SlowLog:     NewSlowLog(DefaultSlowThreshold, DefaultSlowLogSize),
+		Containers:  NewRegistry(c),
 		Log: log.WithFields(log.Fields{
 			"Api": "Dage protocol",
 		}),
 	}
 }
 
-func NewDageClient() *DageClient {
-	return new(DageClient)
-}
-
 func (d *Dage) Listen(port string) {
 	d.Log.Info(fmt.Sprintf("Start Dage server on %s", port))
-	Listener, err := net.Listen("tcp", port)
+	Listener, err := listen(port, d.TLS)
 	if err != nil {
 		d.Log.Error(fmt.Sprintf("Listen dage server on %s error[%s]", port, err.Error()))
 		os.Exit(1)
@@ -68,72 +111,201 @@ func (d *Dage) Listen(port string) {
 	go func(listener net.Listener) {
 		for {
 			Cli, err := listener.Accept()
-			d.Log.Info(fmt.Sprintf("Accept a dage client[%s]", Cli.RemoteAddr().String()))
 			if err != nil {
-				continue
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					continue
+				}
+				d.Log.Error(fmt.Sprintf("Accept dage client error[%s]", err.Error()))
+				return
 			}
-			client := &Client{Conn: Cli, Last: time.Now().Unix()}
+			d.Log.Info(fmt.Sprintf("Accept a dage client[%s]", Cli.RemoteAddr().String()))
+			client := d.newClient(Cli)
+			d.Mut.Lock()
 			d.Clients = append(d.Clients, client)
+			d.Mut.Unlock()
 			go d.Handle(client)
 		}
 	}(Listener)
 	go d.Heart()
 }
 
+func (d *Dage) newClient(conn net.Conn) *Client {
+	n := d.MaxInFlight
+	if n <= 0 {
+		n = 1
+	}
+	return &Client{
+		Mut:      &sync.Mutex{},
+		Conn:     conn,
+		Last:     time.Now().Unix(),
+		inflight: make(chan struct{}, n),
+		wg:       &sync.WaitGroup{},
+	}
+}
+
 func (d *Dage) Heart() {
 	for {
 		<-time.After(time.Second)
 		now := time.Now().Unix()
-		for i, cli := range d.Clients {
-			if now-cli.Last > GiveUpTime {
-				// shutdown connection
+		d.Mut.Lock()
+		for _, cli := range d.Clients {
+			if now-atomic.LoadInt64(&cli.Last) > GiveUpTime {
+				// shutdown connection, Handle removes the client
 				d.Log.Warning(fmt.Sprintf("Dage client %s timeout", cli.Conn.RemoteAddr().String()))
 				cli.Conn.Close()
-				d.Clients = append(d.Clients[0:i], d.Clients[i+1:]...)
-				break
 			}
 		}
+		d.Mut.Unlock()
+	}
+}
+
+// removeClient forgets cli and closes its subscriptions
+func (d *Dage) removeClient(cli *Client) {
+	d.Mut.Lock()
+	for i, c := range d.Clients {
+		if c == cli {
+			d.Clients = append(d.Clients[0:i], d.Clients[i+1:]...)
+			break
+		}
 	}
+	d.Mut.Unlock()
+	cli.Mut.Lock()
+	subs := cli.subs
+	cli.subs = nil
+	cli.Mut.Unlock()
+	for _, sub := range subs {
+		sub.Close()
+	}
+	cli.Conn.Close()
+}
+
+func (cli *Client) Write(b []byte) (int, error) {
+	cli.Mut.Lock()
+	defer cli.Mut.Unlock()
+	return cli.Conn.Write(b)
+}
+
+// process runs f in background once cli has a free in-flight slot, so responses
+// of pipelined commands are written as they finish and matched by tick
+func (cli *Client) process(f func()) {
+	cli.inflight <- struct{}{}
+	cli.wg.Add(1)
+	go func() {
+		defer cli.wg.Done()
+		defer func() { <-cli.inflight }()
+		f()
+	}()
 }
 
+// Handle serves a client, the first byte of connection chooses binary or text protocol
 func (d *Dage) Handle(cli *Client) {
-	s := bufio.NewScanner(cli.Conn)
+	defer d.removeClient(cli)
+	defer cli.wg.Wait()
+	r := bufio.NewReader(cli.Conn)
+	if b, err := r.Peek(1); err == nil && b[0] == BinaryMagic[0] {
+		d.HandleBinary(cli, r)
+		return
+	}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), MaxFrameSize)
 	for s.Scan() {
 		l := s.Text()
 		cmd := strings.Split(l, "\t")
-		resp := d.Command(cmd, cli)
-		if resp != "" {
-			_, err := cli.Conn.Write([]byte(resp))
-			if err != nil {
-				d.Log.Error(fmt.Sprintf("Write data to %s error[%s]", cli.Conn.RemoteAddr().String(), err.Error()))
+		reply := func() {
+			resp := d.Command(cmd, cli)
+			if resp != "" {
+				if _, err := cli.Write([]byte(resp)); err != nil {
+					d.Log.Error(fmt.Sprintf("Write data to %s error[%s]", cli.Conn.RemoteAddr().String(), err.Error()))
+					cli.Conn.Close()
+				}
+			}
+		}
+		if len(cmd) > 1 {
+			switch strings.ToUpper(cmd[1]) {
+			case Quit:
+				//commands before QUIT are answered first
+				cli.wg.Wait()
+				d.Command(cmd, cli)
 				return
+			case Login, Select:
+				//commands after AUTH and SELECT run with their token and container
+				cli.wg.Wait()
+				reply()
+				continue
 			}
 		}
+		cli.process(reply)
+	}
+	if err := s.Err(); err != nil {
+		d.Log.Warning(fmt.Sprintf("Read from %s error[%s]", cli.Conn.RemoteAddr().String(), err.Error()))
 	}
 	//client quit
 }
 
 func (d *Dage) Command(cmd []string, cli *Client) string {
-	cli.Last = time.Now().Unix()
+	atomic.StoreInt64(&cli.Last, time.Now().Unix())
 	resp := []string{}
 	if len(cmd) <= 1 {
 		d.Log.Warning("Invalid protocol")
 		return ""
 	}
 	t := cmd[0]
-	c := cmd[1]
-	switch strings.ToUpper(c) {
+	c := strings.ToUpper(cmd[1])
+	start := time.Now()
+	defer func() {
+		name, bulk, size := c, "", 0
+		if _, ok := textCommands[c]; !ok {
+			name = "UNKNOWN"
+		}
+		if len(cmd) > 2 && c != Login {
+			bulk = cmd[2]
+		}
+		for _, p := range cmd[2:] {
+			size += len(p)
+		}
+		d.observe(cli, name, bulk, size, start)
+	}()
+	if err := d.permit(cli, c, cmd[2:]); err != nil {
+		d.Log.Warning(fmt.Sprintf("Client %s %s %s", cli.Conn.RemoteAddr().String(), c, err.Error()))
+		return strings.Join([]string{t, Denied, "\n"}, " ")
+	}
+	db := d.container(cli)
+	switch c {
 	case Ping:
 		resp = append(resp, t, Pong)
+	case Login:
+		resp = d.AuthCommand(t, cmd[2:], cli)
 	case Quit:
-		cli.Conn.Write([]byte("Good luck!\n"))
+		cli.Write([]byte("Good luck!\n"))
 		cli.Conn.Close()
 	case Set:
-		resp = d.SetCommand(t, cmd[2:])
+		resp = d.SetCommand(t, cmd[2:], db)
 	case GET:
-		resp = d.GetCommand(t, cmd[2:])
+		resp = d.GetCommand(t, cmd[2:], db)
 	case Remove:
-		resp = d.RemoveCommand(t, cmd[2:])
+		resp = d.RemoveCommand(t, cmd[2:], db)
+	case MSet:
+		resp = d.MSetCommand(t, cmd[2:], db)
+	case MGet:
+		resp = d.MGetCommand(t, cmd[2:], db)
+	case MRemove:
+		resp = d.MRemoveCommand(t, cmd[2:], db)
+	case Range:
+		resp = d.RangeCommand(t, cmd[2:], db)
+	case Scan:
+		resp = d.ScanCommand(t, cmd[2:], db)
+	case Sub:
+		resp = d.SubscribeCommand(t, cmd[2:], cli)
+	case Unsub:
+		resp = d.UnsubscribeCommand(t, cmd[2:], cli)
+	case SlowCmd:
+		resp = d.SlowLogCommand(t, cmd[2:])
+	case Create:
+		resp = d.CreateCommand(t, cmd[2:], db)
+	case Select:
+		resp = d.SelectCommand(t, cmd[2:], cli)
+	default:
+		resp = append(resp, t, Failure)
 	}
 	if len(resp) > 0 {
 		resp = append(resp, "\n")
@@ -141,18 +313,116 @@ func (d *Dage) Command(cmd []string, cli *Client) string {
 	return strings.Join(resp, " ")
 }
 
+// permit checks token of cli for text command c
+func (d *Dage) permit(cli *Client, c string, params []string) error {
+	switch c {
+	case Ping, Quit, Login:
+		return nil
+	case Set, Remove, Create:
+		if len(params) == 0 {
+			return nil
+		}
+		return d.Auth.Permit(cli.Token(), true, params[0])
+	case MSet:
+		bulks := []string{}
+		for i := 0; i < len(params); i += 4 {
+			bulks = append(bulks, params[i])
+		}
+		return d.Auth.Permit(cli.Token(), true, bulks...)
+	case MRemove:
+		return d.Auth.Permit(cli.Token(), true, params...)
+	case SlowCmd:
+		//only a token writing every bulk resets the slow log
+		if len(params) > 0 && strings.ToUpper(params[0]) == SlowReset {
+			return d.Auth.Permit(cli.Token(), true, "")
+		}
+	}
+	return d.Auth.Permit(cli.Token(), false)
+}
+
+// observe records latency of command and logs it if slow
+func (d *Dage) observe(cli *Client, command, bulk string, size int, start time.Time) {
+	elapsed := time.Since(start)
+	d.Latency.Observe(command, elapsed)
+	if d.SlowLog != nil && d.SlowLog.Slow(elapsed) {
+		d.SlowLog.Record(SlowEntry{
+			Time:     start,
+			Command:  command,
+			Bulk:     bulk,
+			Size:     size,
+			Duration: elapsed,
+			Client:   cli.Conn.RemoteAddr().String(),
+		})
+	}
+}
+
+// container returns the container selected by cli
+func (d *Dage) container(cli *Client) *Container {
+	cli.Mut.Lock()
+	db := cli.db
+	cli.Mut.Unlock()
+	if db == nil {
+		db = d.Containers.Default()
+	}
+	return db
+}
+
+// selectContainer makes commands of cli run on container of name
+func (d *Dage) selectContainer(cli *Client, name string) error {
+	db, ok := d.Containers.Get(name)
+	if !ok {
+		return ErrContainerNotFound
+	}
+	cli.Mut.Lock()
+	cli.db = db
+	cli.Mut.Unlock()
+	d.Log.Info(fmt.Sprintf("Client %s selects container %s", cli.Conn.RemoteAddr().String(), db.Name))
+	return nil
+}
+
+func (cli *Client) Token() *Token {
+	cli.Mut.Lock()
+	defer cli.Mut.Unlock()
+	return cli.token
+}
+
+// authenticate sets token of cli by secret
+func (d *Dage) authenticate(cli *Client, secret string) error {
+	if d.Auth == nil {
+		return nil
+	}
+	t, err := d.Auth.Authenticate(secret)
+	if err != nil {
+		d.Log.Warning(fmt.Sprintf("Client %s auth failed", cli.Conn.RemoteAddr().String()))
+		return err
+	}
+	cli.Mut.Lock()
+	cli.token = t
+	cli.Mut.Unlock()
+	return nil
+}
+
+//params token
+//response Success or Failure, server without tokens accepts any token
+func (d *Dage) AuthCommand(tick string, params []string, cli *Client) []string {
+	if len(params) != 1 || d.authenticate(cli, params[0]) != nil {
+		return []string{tick, Failure}
+	}
+	return []string{tick, Success}
+}
+
 //params bulkname key value expire
 //response Success or Failure
-func (d *Dage) SetCommand(tick string, params []string) []string {
+func (d *Dage) SetCommand(tick string, params []string, db *Container) []string {
 	if len(params) != 4 {
-		return []string{Failure}
+		return []string{tick, Failure}
 	}
 	expire, err := strconv.Atoi(params[3])
 	if err != nil {
-		return []string{Failure}
+		return []string{tick, Failure}
 	}
-	if err := Default.Add(params[0], params[1], []byte(params[2]), time.Duration(expire)*time.Second); err != nil {
-		return []string{Failure}
+	if err := db.Add(params[0], params[1], []byte(params[2]), time.Duration(expire)*time.Second); err != nil {
+		return []string{tick, Failure}
 	}
 	d.Log.Info(fmt.Sprintf("Add %d bytes to %s", len(params[2]), params[0]))
 	return []string{tick, Success}
@@ -160,13 +430,13 @@ func (d *Dage) SetCommand(tick string, params []string) []string {
 
 //params bulkname
 //response value1 \t value2 \t value3
-func (d *Dage) GetCommand(tick string, params []string) []string {
+func (d *Dage) GetCommand(tick string, params []string, db *Container) []string {
 	if len(params) != 1 {
-		return []string{""}
+		return []string{tick, ""}
 	}
-	its, ok := Default.Get(params[0])
+	its, ok := db.Get(params[0])
 	if !ok {
-		return []string{""}
+		return []string{tick, ""}
 	}
 	items := []string{}
 	bytes := 0
@@ -179,17 +449,281 @@ func (d *Dage) GetCommand(tick string, params []string) []string {
 	return []string{tick, r}
 }
 
+//params bulkname key value expire [bulkname key value expire ...]
+//response Success or Failure of each item \t separated
+func (d *Dage) MSetCommand(tick string, params []string, db *Container) []string {
+	if len(params) == 0 || len(params)%4 != 0 {
+		return []string{tick, Failure}
+	}
+	items := make([]BatchItem, 0, len(params)/4)
+	for i := 0; i < len(params); i += 4 {
+		expire, err := strconv.Atoi(params[i+3])
+		if err != nil {
+			return []string{tick, Failure}
+		}
+		items = append(items, BatchItem{Bulk: params[i], Key: params[i+1], Value: []byte(params[i+2]), Expire: time.Duration(expire) * time.Second})
+	}
+	results := []string{}
+	for _, err := range db.AddMany(items) {
+		if err != nil {
+			results = append(results, Failure)
+		} else {
+			results = append(results, Success)
+		}
+	}
+	d.Log.Info(fmt.Sprintf("Add %d items in batch", len(items)))
+	return []string{tick, strings.Join(results, "\t")}
+}
+
+//params bulkname [bulkname ...]
+//response item count of each bulk followed by its values as OpMGet, -1 for a missing bulk,
+//all \t\t separated
+func (d *Dage) MGetCommand(tick string, params []string, db *Container) []string {
+	if len(params) == 0 {
+		return []string{tick, ""}
+	}
+	fields := []string{}
+	bytes := 0
+	for _, p := range params {
+		its, ok := db.Get(p)
+		if !ok {
+			fields = append(fields, "-1")
+			continue
+		}
+		fields = append(fields, strconv.Itoa(len(its)))
+		for _, i := range its {
+			bytes += len(i.Data)
+			fields = append(fields, string(i.Data))
+		}
+	}
+	d.Log.Info(fmt.Sprintf("From %d Bulks Get %d bytes data", len(params), bytes))
+	return []string{tick, strings.Join(fields, "\t\t")}
+}
+
+//params bulkname [bulkname ...]
+//response Success or Failure
+func (d *Dage) MRemoveCommand(tick string, params []string, db *Container) []string {
+	if len(params) == 0 {
+		return []string{tick, Failure}
+	}
+	for _, p := range params {
+		d.RemoveCommand(tick, []string{p}, db)
+	}
+	return []string{tick, Success}
+}
+
+//params bulkname from to limit, from and to are unix seconds
+//response value1 \t expire1 \t\t value2 \t expire2 ordered by expire
+func (d *Dage) RangeCommand(tick string, params []string, db *Container) []string {
+	if len(params) != 4 {
+		return []string{tick, ""}
+	}
+	ts := make([]int64, 3)
+	for i, p := range params[1:] {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return []string{tick, ""}
+		}
+		ts[i] = n
+	}
+	its, err := db.Range(params[0], time.Unix(ts[0], 0), time.Unix(ts[1], 0), int(ts[2]))
+	if err != nil {
+		return []string{tick, ""}
+	}
+	items := []string{}
+	for _, i := range its {
+		items = append(items, fmt.Sprintf("%s\t%d", i.Data, i.Expire.Unix()))
+	}
+	d.Log.Info(fmt.Sprintf("From Bulk %s Range %d items", params[0], len(items)))
+	return []string{tick, strings.Join(items, "\t\t")}
+}
+
+//params bulkname cursor count, scan starts with cursor 0
+//response next_cursor value1 \t\t value2, next cursor 0 ends the scan
+func (d *Dage) ScanCommand(tick string, params []string, db *Container) []string {
+	if len(params) != 3 {
+		return []string{tick, ""}
+	}
+	count, err := strconv.Atoi(params[2])
+	if err != nil {
+		return []string{tick, ""}
+	}
+	its, next, err := db.Scan(params[0], params[1], count)
+	if err != nil {
+		return []string{tick, ""}
+	}
+	items := []string{}
+	for _, i := range its {
+		items = append(items, string(i.Data))
+	}
+	return []string{tick, next, strings.Join(items, "\t\t")}
+}
+
+//params bulkname [add,expire,evict,remove], * subscribes every bulk
+//response Success or Failure, then an event line pre event
+//event tick \t EVENT \t type \t bulkname \t key \t value \t expire
+func (d *Dage) SubscribeCommand(tick string, params []string, cli *Client) []string {
+	if len(params) < 1 || len(params) > 2 {
+		return []string{tick, Failure}
+	}
+	events := EventAll
+	if len(params) == 2 {
+		var err error
+		if events, err = ParseEventTypes(params[1]); err != nil {
+			return []string{tick, Failure}
+		}
+	}
+	bulk := params[0]
+	if bulk == "*" {
+		bulk = ""
+	}
+	d.subscribe(cli, bulk, events, func(e Event) []byte {
+		l := strings.Join([]string{tick, Emit, e.Type.String(), e.Bulk, TrimKey(e.Key), string(e.Data), strconv.FormatInt(e.Expire.Unix(), 10)}, "\t")
+		return []byte(l + "\n")
+	})
+	return []string{tick, Success}
+}
+
+// subscribe forwards events of bulk to cli, encode renders an event in protocol of cli
+func (d *Dage) subscribe(cli *Client, bulk string, events EventType, encode func(Event) []byte) {
+	sub := d.container(cli).Subscribe(bulk, events)
+	cli.Mut.Lock()
+	cli.subs = append(cli.subs, sub)
+	cli.Mut.Unlock()
+	go func() {
+		for e := range sub.C {
+			if _, err := cli.Write(encode(e)); err != nil {
+				sub.Close()
+				return
+			}
+			atomic.StoreInt64(&cli.Last, time.Now().Unix())
+		}
+	}()
+	d.Log.Info(fmt.Sprintf("Client %s subscribes %s of bulk %s", cli.Conn.RemoteAddr().String(), events.String(), bulk))
+}
+
+//params [bulkname], without bulkname unsubscribes everything
+//response Success
+func (d *Dage) UnsubscribeCommand(tick string, params []string, cli *Client) []string {
+	if len(params) == 0 {
+		cli.unsubscribe("", true)
+	} else if params[0] == "*" {
+		cli.unsubscribe("", false)
+	} else {
+		cli.unsubscribe(params[0], false)
+	}
+	return []string{tick, Success}
+}
+
+// unsubscribe closes subscriptions of bulk, or every subscription if all
+func (cli *Client) unsubscribe(bulk string, all bool) {
+	cli.Mut.Lock()
+	closed := []*Subscription{}
+	subs := []*Subscription{}
+	for _, sub := range cli.subs {
+		if all || sub.Bulk == bulk {
+			closed = append(closed, sub)
+		} else {
+			subs = append(subs, sub)
+		}
+	}
+	cli.subs = subs
+	cli.Mut.Unlock()
+	for _, sub := range closed {
+		sub.Close()
+	}
+}
+
+//params [GET [count]|LEN|RESET], count defaults to 10
+//response of GET id \t time \t command \t bulkname \t size \t duration \t\t ... newest first,
+//time is unix milliseconds and duration is microseconds
+//response of LEN count, response of RESET Success
+func (d *Dage) SlowLogCommand(tick string, params []string) []string {
+	if d.SlowLog == nil {
+		return []string{tick, Failure}
+	}
+	sub := SlowGet
+	if len(params) > 0 {
+		sub = strings.ToUpper(params[0])
+	}
+	switch sub {
+	case SlowGet:
+		n := DefaultSlowLogCount
+		if len(params) > 1 {
+			var err error
+			if n, err = strconv.Atoi(params[1]); err != nil {
+				return []string{tick, Failure}
+			}
+		}
+		entries := []string{}
+		for _, e := range d.SlowLog.Entries(n) {
+			entries = append(entries, strings.Join([]string{
+				strconv.FormatUint(e.ID, 10),
+				strconv.FormatInt(milli(e.Time), 10),
+				e.Command,
+				e.Bulk,
+				strconv.Itoa(e.Size),
+				strconv.FormatInt(int64(e.Duration/time.Microsecond), 10),
+				e.Client,
+			}, "\t"))
+		}
+		return []string{tick, strings.Join(entries, "\t\t")}
+	case SlowLen:
+		return []string{tick, strconv.Itoa(d.SlowLog.Len())}
+	case SlowReset:
+		d.SlowLog.Reset()
+		return []string{tick, Success}
+	}
+	return []string{tick, Failure}
+}
+
+//params container, subscriptions keep their container
+//response Success or Failure if container is not found
+func (d *Dage) SelectCommand(tick string, params []string, cli *Client) []string {
+	if len(params) != 1 || d.selectContainer(cli, params[0]) != nil {
+		return []string{tick, Failure}
+	}
+	return []string{tick, Success}
+}
+
+//params bulkname maxitem eliminate_ms [eviction_policy [shards]]
+//response Success, or Failure followed by the validation error
+func (d *Dage) CreateCommand(tick string, params []string, db *Container) []string {
+	if len(params) < 3 || len(params) > 5 {
+		return []string{tick, Failure, "Invalid params"}
+	}
+	max, err := strconv.Atoi(params[1])
+	if err != nil {
+		return []string{tick, Failure, fmt.Sprintf("Invalid maxitem %s", params[1])}
+	}
+	eliminate, err := strconv.ParseInt(params[2], 10, 64)
+	if err != nil {
+		return []string{tick, Failure, fmt.Sprintf("Invalid eliminate %s", params[2])}
+	}
+	cfg := &BulkConfig{MaxItem: max, Eliminate: time.Duration(eliminate) * time.Millisecond}
+	if len(params) > 3 {
+		if cfg.EvictionPolicy, err = ParseEvictionPolicy(params[3]); err != nil {
+			return []string{tick, Failure, err.Error()}
+		}
+	}
+	if len(params) > 4 {
+		if cfg.Shards, err = strconv.Atoi(params[4]); err != nil {
+			return []string{tick, Failure, fmt.Sprintf("Invalid shards %s", params[4])}
+		}
+	}
+	if _, err := db.SetBulkConfig(params[0], cfg); err != nil {
+		return []string{tick, Failure, err.Error()}
+	}
+	return []string{tick, Success}
+}
+
 //params bulkname
 //response Success or Failure
-func (d *Dage) RemoveCommand(tick string, params []string) []string {
+func (d *Dage) RemoveCommand(tick string, params []string, db *Container) []string {
 	if len(params) != 1 {
-		return []string{Failure}
+		return []string{tick, Failure}
 	}
-	Default.Remove(params[0])
+	db.Remove(params[0])
 	d.Log.Info(fmt.Sprintf("Deleted Bulk %s", params[0]))
 	return []string{tick, Success}
 }
-
-func init() {
-	DageApi = NewDage()
-}
@@ -0,0 +1,391 @@
+package bulkCache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HandleBinary answers the handshake and serves frames of cli until it quits
+func (d *Dage) HandleBinary(cli *Client, r *bufio.Reader) {
+	version, err := readHandshake(r)
+	if err != nil {
+		d.Log.Warning(fmt.Sprintf("Handshake of %s error[%s]", cli.Conn.RemoteAddr().String(), err.Error()))
+		return
+	}
+	if version > BinaryVersion {
+		version = BinaryVersion
+	}
+	if _, err := cli.Write(handshake(version)); err != nil || version == 0 {
+		return
+	}
+	for {
+		f, err := ReadFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				d.Log.Warning(fmt.Sprintf("Read frame from %s error[%s]", cli.Conn.RemoteAddr().String(), err.Error()))
+			}
+			return
+		}
+		reply := func() {
+			resp := d.BinaryCommand(f, cli)
+			if _, err := cli.Write(resp.Bytes()); err != nil {
+				d.Log.Error(fmt.Sprintf("Write data to %s error[%s]", cli.Conn.RemoteAddr().String(), err.Error()))
+				cli.Conn.Close()
+			}
+		}
+		switch f.Code {
+		case OpQuit:
+			//commands before QUIT are answered first
+			cli.wg.Wait()
+			cli.Write(d.BinaryCommand(f, cli).Bytes())
+			cli.Conn.Close()
+			return
+		case OpAuth, OpSelect:
+			//commands after AUTH and SELECT run with their token and container
+			cli.wg.Wait()
+			reply()
+		default:
+			cli.process(reply)
+		}
+	}
+}
+
+var (
+	// opNames names opcodes as text commands
+	opNames = map[byte]string{
+		OpPing: Ping, OpSet: Set, OpGet: GET, OpRemove: Remove, OpRange: Range, OpScan: Scan,
+		OpSubscribe: Sub, OpUnsubscribe: Unsub, OpQuit: Quit, OpMSet: MSet, OpMGet: MGet,
+		OpMRemove: MRemove, OpAuth: Login, OpSlowLog: SlowCmd,
+		OpCreate: Create, OpSelect: Select,
+	}
+)
+
+func (d *Dage) BinaryCommand(f *Frame, cli *Client) *Frame {
+	atomic.StoreInt64(&cli.Last, time.Now().Unix())
+	start := time.Now()
+	defer func() {
+		name, ok := opNames[f.Code]
+		if !ok {
+			name = "UNKNOWN"
+		}
+		bulk, size := "", 0
+		if len(f.Fields) > 0 && f.Code != OpAuth {
+			bulk = string(f.Fields[0])
+		}
+		for _, field := range f.Fields {
+			size += len(field)
+		}
+		d.observe(cli, name, bulk, size, start)
+	}()
+	if err := d.permitFrame(cli, f); err != nil {
+		d.Log.Warning(fmt.Sprintf("Client %s opcode %d %s", cli.Conn.RemoteAddr().String(), f.Code, err.Error()))
+		return NewFrame(StatusDenied, f.Tick)
+	}
+	db := d.container(cli)
+	switch f.Code {
+	case OpPing, OpQuit:
+		return NewFrame(StatusOK, f.Tick)
+	case OpAuth:
+		if len(f.Fields) != 1 || d.authenticate(cli, string(f.Fields[0])) != nil {
+			return NewFrame(StatusFailure, f.Tick)
+		}
+		return NewFrame(StatusOK, f.Tick)
+	case OpSet:
+		return d.binarySet(f, db)
+	case OpGet:
+		return d.binaryGet(f, db)
+	case OpRemove:
+		if len(f.Fields) != 1 {
+			return NewFrame(StatusBadRequest, f.Tick)
+		}
+		db.Remove(string(f.Fields[0]))
+		d.Log.Info(fmt.Sprintf("Deleted Bulk %s", f.Fields[0]))
+		return NewFrame(StatusOK, f.Tick)
+	case OpMSet:
+		return d.binaryMSet(f, db)
+	case OpMGet:
+		return d.binaryMGet(f, db)
+	case OpMRemove:
+		for _, bulk := range f.Fields {
+			db.Remove(string(bulk))
+		}
+		d.Log.Info(fmt.Sprintf("Deleted %d Bulks", len(f.Fields)))
+		return NewFrame(StatusOK, f.Tick)
+	case OpRange:
+		return d.binaryRange(f, db)
+	case OpScan:
+		return d.binaryScan(f, db)
+	case OpSubscribe:
+		return d.binarySubscribe(f, cli)
+	case OpSlowLog:
+		return d.binarySlowLog(f)
+	case OpCreate:
+		return d.binaryCreate(f, db)
+	case OpSelect:
+		if len(f.Fields) != 1 {
+			return NewFrame(StatusBadRequest, f.Tick)
+		}
+		if d.selectContainer(cli, string(f.Fields[0])) != nil {
+			return NewFrame(StatusNotFound, f.Tick)
+		}
+		return NewFrame(StatusOK, f.Tick)
+	case OpUnsubscribe:
+		if len(f.Fields) == 0 {
+			cli.unsubscribe("", true)
+		} else {
+			cli.unsubscribe(string(f.Fields[0]), false)
+		}
+		return NewFrame(StatusOK, f.Tick)
+	}
+	return NewFrame(StatusUnknown, f.Tick)
+}
+
+// permitFrame checks token of cli for request f
+func (d *Dage) permitFrame(cli *Client, f *Frame) error {
+	switch f.Code {
+	case OpPing, OpQuit, OpAuth:
+		return nil
+	case OpSet, OpRemove, OpCreate:
+		if len(f.Fields) == 0 {
+			return nil
+		}
+		return d.Auth.Permit(cli.Token(), true, string(f.Fields[0]))
+	case OpMSet, OpMRemove:
+		step := 1
+		if f.Code == OpMSet {
+			step = 4
+		}
+		bulks := []string{}
+		for i := 0; i < len(f.Fields); i += step {
+			bulks = append(bulks, string(f.Fields[i]))
+		}
+		return d.Auth.Permit(cli.Token(), true, bulks...)
+	case OpSlowLog:
+		if len(f.Fields) > 0 && strings.ToUpper(string(f.Fields[0])) == SlowReset {
+			return d.Auth.Permit(cli.Token(), true, "")
+		}
+	}
+	return d.Auth.Permit(cli.Token(), false)
+}
+
+//fields bulkname key value expire_ms
+func (d *Dage) binarySet(f *Frame, db *Container) *Frame {
+	if len(f.Fields) != 4 {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	expire, err := f.Int(3)
+	if err != nil {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	bulk := string(f.Fields[0])
+	if err := db.Add(bulk, string(f.Fields[1]), f.Fields[2], time.Duration(expire)*time.Millisecond); err != nil {
+		return NewFrame(StatusFailure, f.Tick)
+	}
+	d.Log.Info(fmt.Sprintf("Add %d bytes to %s", len(f.Fields[2]), bulk))
+	return NewFrame(StatusOK, f.Tick)
+}
+
+//fields bulkname
+func (d *Dage) binaryGet(f *Frame, db *Container) *Frame {
+	if len(f.Fields) != 1 {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	its, ok := db.Get(string(f.Fields[0]))
+	if !ok {
+		return NewFrame(StatusNotFound, f.Tick)
+	}
+	resp := NewFrame(StatusOK, f.Tick)
+	for _, i := range its {
+		resp.Fields = append(resp.Fields, i.Data)
+	}
+	return resp
+}
+
+//fields bulkname key value expire_ms [bulkname key value expire_ms ...]
+func (d *Dage) binaryMSet(f *Frame, db *Container) *Frame {
+	if len(f.Fields) == 0 || len(f.Fields)%4 != 0 {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	items := make([]BatchItem, 0, len(f.Fields)/4)
+	for i := 0; i < len(f.Fields); i += 4 {
+		expire, err := f.Int(i + 3)
+		if err != nil {
+			return NewFrame(StatusBadRequest, f.Tick)
+		}
+		items = append(items, BatchItem{Bulk: string(f.Fields[i]), Key: string(f.Fields[i+1]), Value: f.Fields[i+2], Expire: time.Duration(expire) * time.Millisecond})
+	}
+	resp := NewFrame(StatusOK, f.Tick)
+	for _, err := range db.AddMany(items) {
+		if err != nil {
+			resp.Fields = append(resp.Fields, []byte{StatusFailure})
+		} else {
+			resp.Fields = append(resp.Fields, []byte{StatusOK})
+		}
+	}
+	d.Log.Info(fmt.Sprintf("Add %d items in batch", len(items)))
+	return resp
+}
+
+//fields bulkname [bulkname ...]
+func (d *Dage) binaryMGet(f *Frame, db *Container) *Frame {
+	resp := NewFrame(StatusOK, f.Tick)
+	for _, bulk := range f.Fields {
+		its, ok := db.Get(string(bulk))
+		if !ok {
+			resp.Fields = append(resp.Fields, IntField(-1))
+			continue
+		}
+		resp.Fields = append(resp.Fields, IntField(int64(len(its))))
+		for _, i := range its {
+			resp.Fields = append(resp.Fields, i.Data)
+		}
+	}
+	return resp
+}
+
+//fields bulkname from_ms to_ms limit
+func (d *Dage) binaryRange(f *Frame, db *Container) *Frame {
+	if len(f.Fields) != 4 {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	ns := make([]int64, 3)
+	for i := range ns {
+		n, err := f.Int(i + 1)
+		if err != nil {
+			return NewFrame(StatusBadRequest, f.Tick)
+		}
+		ns[i] = n
+	}
+	its, err := db.Range(string(f.Fields[0]), unixMilli(ns[0]), unixMilli(ns[1]), int(ns[2]))
+	if err != nil {
+		return NewFrame(StatusNotFound, f.Tick)
+	}
+	resp := NewFrame(StatusOK, f.Tick)
+	for _, i := range its {
+		resp.Fields = append(resp.Fields, i.Data, IntField(milli(i.Expire)))
+	}
+	return resp
+}
+
+//fields bulkname cursor count
+func (d *Dage) binaryScan(f *Frame, db *Container) *Frame {
+	if len(f.Fields) != 3 {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	count, err := f.Int(2)
+	if err != nil {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	its, next, err := db.Scan(string(f.Fields[0]), string(f.Fields[1]), int(count))
+	if err != nil {
+		return NewFrame(StatusNotFound, f.Tick)
+	}
+	resp := NewFrame(StatusOK, f.Tick, []byte(next))
+	for _, i := range its {
+		resp.Fields = append(resp.Fields, i.Data)
+	}
+	return resp
+}
+
+//fields bulkname [events], an empty bulkname subscribes every bulk
+func (d *Dage) binarySubscribe(f *Frame, cli *Client) *Frame {
+	if len(f.Fields) < 1 || len(f.Fields) > 2 {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	events := EventAll
+	if len(f.Fields) == 2 {
+		var err error
+		if events, err = ParseEventTypes(string(f.Fields[1])); err != nil {
+			return NewFrame(StatusBadRequest, f.Tick)
+		}
+	}
+	tick := f.Tick
+	d.subscribe(cli, string(f.Fields[0]), events, func(e Event) []byte {
+		return NewFrame(StatusEvent, tick, []byte(e.Type.String()), []byte(e.Bulk), []byte(TrimKey(e.Key)), e.Data, IntField(milli(e.Expire))).Bytes()
+	})
+	return NewFrame(StatusOK, f.Tick)
+}
+
+//fields bulkname maxitem eliminate_ms [eviction_policy [shards]]
+func (d *Dage) binaryCreate(f *Frame, db *Container) *Frame {
+	if len(f.Fields) < 3 || len(f.Fields) > 5 {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	max, err := f.Int(1)
+	if err != nil {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	eliminate, err := f.Int(2)
+	if err != nil {
+		return NewFrame(StatusBadRequest, f.Tick)
+	}
+	cfg := &BulkConfig{MaxItem: int(max), Eliminate: time.Duration(eliminate) * time.Millisecond}
+	if len(f.Fields) > 3 {
+		if cfg.EvictionPolicy, err = ParseEvictionPolicy(string(f.Fields[3])); err != nil {
+			return NewFrame(StatusFailure, f.Tick, []byte(err.Error()))
+		}
+	}
+	if len(f.Fields) > 4 {
+		shards, err := f.Int(4)
+		if err != nil {
+			return NewFrame(StatusBadRequest, f.Tick)
+		}
+		cfg.Shards = int(shards)
+	}
+	if _, err := db.SetBulkConfig(string(f.Fields[0]), cfg); err != nil {
+		return NewFrame(StatusFailure, f.Tick, []byte(err.Error()))
+	}
+	return NewFrame(StatusOK, f.Tick)
+}
+
+//fields [GET [count]|LEN|RESET]
+func (d *Dage) binarySlowLog(f *Frame) *Frame {
+	if d.SlowLog == nil {
+		return NewFrame(StatusFailure, f.Tick)
+	}
+	sub := SlowGet
+	if len(f.Fields) > 0 {
+		sub = strings.ToUpper(string(f.Fields[0]))
+	}
+	switch sub {
+	case SlowGet:
+		n := int64(DefaultSlowLogCount)
+		if len(f.Fields) > 1 {
+			var err error
+			if n, err = f.Int(1); err != nil {
+				return NewFrame(StatusBadRequest, f.Tick)
+			}
+		}
+		resp := NewFrame(StatusOK, f.Tick)
+		for _, e := range d.SlowLog.Entries(int(n)) {
+			resp.Fields = append(resp.Fields,
+				IntField(int64(e.ID)),
+				IntField(milli(e.Time)),
+				[]byte(e.Command),
+				[]byte(e.Bulk),
+				IntField(int64(e.Size)),
+				IntField(int64(e.Duration/time.Microsecond)),
+				[]byte(e.Client),
+			)
+		}
+		return resp
+	case SlowLen:
+		return NewFrame(StatusOK, f.Tick, IntField(int64(d.SlowLog.Len())))
+	case SlowReset:
+		d.SlowLog.Reset()
+		return NewFrame(StatusOK, f.Tick)
+	}
+	return NewFrame(StatusBadRequest, f.Tick)
+}
+
+func milli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func unixMilli(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,91 @@
+package bulkCache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type (
+	// EngineFactory creates a bulk of an engine, cached is nil for an empty bulk.
+	// The bulk runs no goroutine until the container attaches it
+	EngineFactory func(cfg *BulkConfig, cached Cached) Bulk
+)
+
+var (
+	enginesMut = new(sync.RWMutex)
+	engines    = map[string]EngineFactory{
+		HashEngine:    newHashEngine,
+		BTreeEngine:   newBTreeEngine,
+		WheelEngine:   newWheelEngine,
+		ShardedEngine: newShardedEngine,
+	}
+)
+
+// RegisterEngine makes a bulk engine available to NewContainer by name,
+// it panics if factory is nil or the name is registered twice
+func RegisterEngine(name string, factory EngineFactory) {
+	enginesMut.Lock()
+	defer enginesMut.Unlock()
+	if factory == nil {
+		panic(fmt.Sprintf("Register engine %s with nil factory", name))
+	}
+	if _, ok := engines[name]; ok {
+		panic(fmt.Sprintf("Register engine %s twice", name))
+	}
+	engines[name] = factory
+}
+
+// unregisterEngine drops an engine registered by RegisterEngine, used by tests
+func unregisterEngine(name string) {
+	enginesMut.Lock()
+	defer enginesMut.Unlock()
+	delete(engines, name)
+}
+
+// Engines returns the sorted names of registered engines
+func Engines() []string {
+	enginesMut.RLock()
+	defer enginesMut.RUnlock()
+	names := []string{}
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetEngine(name string) (EngineFactory, bool) {
+	enginesMut.RLock()
+	defer enginesMut.RUnlock()
+	f, ok := engines[name]
+	return f, ok
+}
+
+func newHashEngine(cfg *BulkConfig, cached Cached) Bulk {
+	if cached == nil {
+		cached = Cached{}
+	}
+	return newHashBulk(cfg, cached)
+}
+
+func newBTreeEngine(cfg *BulkConfig, cached Cached) Bulk {
+	if cached == nil {
+		cached = Cached{}
+	}
+	return newBTreeBulk(cfg, cached)
+}
+
+func newWheelEngine(cfg *BulkConfig, cached Cached) Bulk {
+	if cached == nil {
+		return NewWheelBulk(cfg)
+	}
+	return NewWheelBulkFromCached(cfg, cached)
+}
+
+func newShardedEngine(cfg *BulkConfig, cached Cached) Bulk {
+	if cached == nil {
+		cached = Cached{}
+	}
+	return newShardedBulk(cfg, cached)
+}
@@ -0,0 +1,175 @@
+package bulkCache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EventAdd EventType = 1 << iota
+	EventExpire
+	EventEvict
+	EventRemove
+
+	EventAll = EventAdd | EventExpire | EventEvict | EventRemove
+
+	// SubscriptionBuffer is the number of events a subscriber can fall behind,
+	// later events are dropped until it catches up
+	SubscriptionBuffer = 256
+)
+
+type (
+	// EventType is a bit of a subscribed events mask
+	EventType int
+
+	// EventHandler is called back by a bulk under its lock, it must not block
+	EventHandler func(t EventType, key string, i *Item)
+
+	// EventBulk is a bulk which reports expirations and evictions of its items
+	EventBulk interface {
+		Bulk
+		OnEvent(EventHandler)
+	}
+
+	Event struct {
+		Type   EventType
+		Bulk   string
+		Key    string
+		Data   []byte
+		Expire time.Time
+	}
+
+	Subscription struct {
+		C         <-chan Event
+		Bulk      string
+		Events    EventType
+		Dropped   int64
+		c         chan Event
+		container *Container
+		once      sync.Once
+	}
+
+	subscriptions struct {
+		Mut   *sync.RWMutex
+		count int32
+		bulks map[string]map[*Subscription]bool
+	}
+)
+
+var (
+	eventTypes = map[EventType]string{
+		EventAdd:    "add",
+		EventExpire: "expire",
+		EventEvict:  "evict",
+		EventRemove: "remove",
+	}
+)
+
+func (t EventType) String() string {
+	names := []string{}
+	for _, e := range []EventType{EventAdd, EventExpire, EventEvict, EventRemove} {
+		if t&e != 0 {
+			names = append(names, eventTypes[e])
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseEventTypes parses comma separated event names, empty string means all events
+func ParseEventTypes(s string) (EventType, error) {
+	if s == "" {
+		return EventAll, nil
+	}
+	var t EventType
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for e, n := range eventTypes {
+			if n == strings.ToLower(strings.TrimSpace(name)) {
+				t |= e
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Unknown event %s", name)
+		}
+	}
+	return t, nil
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{
+		Mut:   &sync.RWMutex{},
+		bulks: map[string]map[*Subscription]bool{},
+	}
+}
+
+// Subscribe receives events of bulk, "" subscribes every bulk.
+// Subscription must be closed when it is no longer read.
+func (c *Container) Subscribe(bulk string, events EventType) *Subscription {
+	ch := make(chan Event, SubscriptionBuffer)
+	sub := &Subscription{
+		C:         ch,
+		Bulk:      bulk,
+		Events:    events,
+		c:         ch,
+		container: c,
+	}
+	s := c.subs
+	s.Mut.Lock()
+	defer s.Mut.Unlock()
+	if s.bulks[bulk] == nil {
+		s.bulks[bulk] = map[*Subscription]bool{}
+	}
+	s.bulks[bulk][sub] = true
+	atomic.AddInt32(&s.count, 1)
+	return sub
+}
+
+// Close unsubscribes and closes C
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		s := sub.container.subs
+		s.Mut.Lock()
+		defer s.Mut.Unlock()
+		delete(s.bulks[sub.Bulk], sub)
+		if len(s.bulks[sub.Bulk]) == 0 {
+			delete(s.bulks, sub.Bulk)
+		}
+		atomic.AddInt32(&s.count, -1)
+		close(sub.c)
+	})
+}
+
+// publish delivers e to subscribers without blocking
+func (c *Container) publish(e Event) {
+	s := c.subs
+	if atomic.LoadInt32(&s.count) == 0 {
+		return
+	}
+	s.Mut.RLock()
+	defer s.Mut.RUnlock()
+	for _, bulk := range []string{e.Bulk, ""} {
+		for sub := range s.bulks[bulk] {
+			if sub.Events&e.Type == 0 {
+				continue
+			}
+			select {
+			case sub.c <- e:
+			default:
+				atomic.AddInt64(&sub.Dropped, 1)
+			}
+		}
+	}
+}
+
+func (c *Container) publishItem(t EventType, bulk, key string, i *Item) {
+	e := Event{Type: t, Bulk: bulk, Key: key}
+	if i != nil {
+		e.Data = i.Data
+		e.Expire = i.Expire
+	}
+	c.publish(e)
+}
@@ -0,0 +1,60 @@
+package bulkCache
+
+import (
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub *Subscription, want EventType) Event {
+	select {
+	case e := <-sub.C:
+		if e.Type != want {
+			t.Errorf("receive %s event, want %s", e.Type.String(), want.String())
+		}
+		return e
+	case <-time.After(time.Second * 2):
+		t.Fatalf("%s event is not received", want.String())
+	}
+	return Event{}
+}
+
+func Test_Subscribe(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine, WheelEngine, ShardedEngine} {
+		c, _ := NewContainer("Events", engine)
+		sub := c.Subscribe("Video", EventAll)
+		all := c.Subscribe("", EventRemove)
+		c.AddBulk("Video", &BulkConfig{MaxItem: 1, Eliminate: time.Millisecond * 100, EvictionPolicy: EvictOldestExpire, Shards: 1})
+
+		c.Add("Video", "a", []byte("Tag a"), time.Millisecond*50)
+		e := receive(t, sub, EventAdd)
+		if e.Bulk != "Video" || TrimKey(e.Key) != "a" || string(e.Data) != "Tag a" {
+			t.Errorf("[%s] add event is %v", engine, e)
+		}
+		e = receive(t, sub, EventExpire)
+		if TrimKey(e.Key) != "a" {
+			t.Errorf("[%s] expire event is %v", engine, e)
+		}
+
+		c.Add("Video", "b", []byte("Tag b"), time.Minute)
+		receive(t, sub, EventAdd)
+		c.Add("Video", "c", []byte("Tag c"), time.Minute)
+		e = receive(t, sub, EventEvict)
+		if TrimKey(e.Key) != "b" {
+			t.Errorf("[%s] evict event is %v", engine, e)
+		}
+		receive(t, sub, EventAdd)
+
+		c.DeleteItem("Video", "c")
+		receive(t, sub, EventRemove)
+		c.Remove("Video")
+		receive(t, sub, EventRemove)
+		receive(t, all, EventRemove)
+		receive(t, all, EventRemove)
+
+		sub.Close()
+		all.Close()
+		if _, ok := <-sub.C; ok {
+			t.Errorf("[%s] closed subscription should be closed", engine)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package bulkCache
+
+import (
+	"container/heap"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// EvictReject rejects writes to a full bulk
+	EvictReject EvictionPolicy = iota
+	// EvictOldestExpire evicts the item which expires first
+	EvictOldestExpire
+	// EvictLRU evicts the least recently used item
+	EvictLRU
+	// EvictLFU evicts the least frequently used item
+	EvictLFU
+	// EvictRandom evicts a random item
+	EvictRandom
+)
+
+const (
+	// EvictionSamples is how many items are sampled to approximate LRU and LFU
+	EvictionSamples = 5
+)
+
+type (
+	EvictionPolicy int
+
+	evictionCandidate struct {
+		key  string
+		item *Item
+	}
+
+	// expireEntry is an item key in expireHeap at index
+	expireEntry struct {
+		key    string
+		expire time.Time
+		index  int
+	}
+
+	// expireHeap is a min heap of keys by expire for exact oldest expire eviction
+	// of engines not ordered by expire, entries maps key to its entry
+	expireHeap struct {
+		es      []*expireEntry
+		entries map[string]*expireEntry
+	}
+)
+
+var (
+	evictionPolicies = map[EvictionPolicy]string{
+		EvictReject:       "reject",
+		EvictOldestExpire: "oldest-expire",
+		EvictLRU:          "lru",
+		EvictLFU:          "lfu",
+		EvictRandom:       "random",
+	}
+)
+
+// itemBytes is the size of an item in analytics, its sub key and value
+func itemBytes(key string, i *Item) int {
+	return len(key) + len(i.Data)
+}
+
+// full tells whether a bulk of n items takes no new item,
+// MaxItem 0 or negative means unlimited
+func (cfg *BulkConfig) full(n int) bool {
+	return cfg.MaxItem > 0 && n >= cfg.MaxItem
+}
+
+// over tells whether a bulk of n items holds more than MaxItem
+func (cfg *BulkConfig) over(n int) bool {
+	return cfg.MaxItem > 0 && n > cfg.MaxItem
+}
+
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	for p, name := range evictionPolicies {
+		if name == s {
+			return p, nil
+		}
+	}
+	return EvictReject, fmt.Errorf("Unknown eviction policy %s", s)
+}
+
+func (p EvictionPolicy) String() string {
+	if name, ok := evictionPolicies[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+}
+
+func newExpireHeap() *expireHeap {
+	return &expireHeap{entries: map[string]*expireEntry{}}
+}
+
+func (h *expireHeap) Len() int           { return len(h.es) }
+func (h *expireHeap) Less(i, j int) bool { return h.es[i].expire.Before(h.es[j].expire) }
+func (h *expireHeap) Swap(i, j int) {
+	h.es[i], h.es[j] = h.es[j], h.es[i]
+	h.es[i].index, h.es[j].index = i, j
+}
+func (h *expireHeap) Push(x interface{}) {
+	e := x.(*expireEntry)
+	e.index = len(h.es)
+	h.es = append(h.es, e)
+}
+func (h *expireHeap) Pop() interface{} {
+	e := h.es[len(h.es)-1]
+	h.es = h.es[:len(h.es)-1]
+	return e
+}
+
+// set puts key at expire or moves it there
+func (h *expireHeap) set(key string, expire time.Time) {
+	if e, ok := h.entries[key]; ok {
+		e.expire = expire
+		heap.Fix(h, e.index)
+		return
+	}
+	e := &expireEntry{key: key, expire: expire}
+	h.entries[key] = e
+	heap.Push(h, e)
+}
+
+func (h *expireHeap) remove(key string) {
+	if e, ok := h.entries[key]; ok {
+		heap.Remove(h, e.index)
+		delete(h.entries, key)
+	}
+}
+
+// first returns the key expiring first
+func (h *expireHeap) first() (string, time.Time, bool) {
+	if len(h.es) == 0 {
+		return "", time.Time{}, false
+	}
+	return h.es[0].key, h.es[0].expire, true
+}
+
+// touch records an access of item for LRU and LFU
+func (i *Item) touch() {
+	atomic.StoreInt64(&i.access, time.Now().UnixNano())
+	atomic.AddInt64(&i.hits, 1)
+}
+
+// selectVictim picks the item to evict from samples by policy
+func selectVictim(policy EvictionPolicy, samples []evictionCandidate) (string, bool) {
+	if len(samples) == 0 {
+		return "", false
+	}
+	//expired items go first whatever the policy is
+	n := time.Now()
+	for _, c := range samples {
+		if n.After(c.item.Expire) {
+			return c.key, true
+		}
+	}
+	v := samples[0]
+	for _, c := range samples[1:] {
+		switch policy {
+		case EvictOldestExpire:
+			if c.item.Expire.Before(v.item.Expire) {
+				v = c
+			}
+		case EvictLRU:
+			if atomic.LoadInt64(&c.item.access) < atomic.LoadInt64(&v.item.access) {
+				v = c
+			}
+		case EvictLFU:
+			if atomic.LoadInt64(&c.item.hits) < atomic.LoadInt64(&v.item.hits) {
+				v = c
+			}
+		}
+	}
+	return v.key, true
+}
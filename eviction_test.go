@@ -0,0 +1,115 @@
+package bulkCache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Eviction(t *testing.T) {
+	engines := map[string]func(*BulkConfig) Bulk{
+		HashEngine:  func(cfg *BulkConfig) Bulk { return NewHashBulk(cfg) },
+		BTreeEngine: func(cfg *BulkConfig) Bulk { return NewBTreeBulk(cfg) },
+	}
+	for name, engine := range engines {
+		b := engine(&BulkConfig{MaxItem: 3, Eliminate: time.Second})
+		for i := 0; i < 3; i++ {
+			b.Add(fmt.Sprint(i), []byte("value"), time.Minute)
+		}
+		if err := b.Add("3", []byte("value"), time.Minute); err != ErrBulkFull {
+			t.Errorf("[%s] reject policy should return ErrBulkFull", name)
+		}
+		if err := b.Add("0", []byte("value"), time.Minute); err != nil {
+			t.Errorf("[%s] overwrite in a full bulk error[%s]", name, err.Error())
+		}
+
+		b = engine(&BulkConfig{MaxItem: 3, Eliminate: time.Second, EvictionPolicy: EvictOldestExpire})
+		for i := 0; i < 3; i++ {
+			b.Add(fmt.Sprint(i), []byte("value"), time.Minute*time.Duration(i+1))
+		}
+		b.Add("3", []byte("value"), time.Hour)
+		if b.Get("0") != nil || b.Get("3") == nil || b.Len() != 3 {
+			t.Errorf("[%s] oldest expire item should be evicted", name)
+		}
+		if b.Analytics().Evictions != 1 {
+			t.Errorf("[%s] eviction is not counted", name)
+		}
+
+		b = engine(&BulkConfig{MaxItem: 3, Eliminate: time.Second, EvictionPolicy: EvictLRU})
+		for i := 0; i < 3; i++ {
+			b.Add(fmt.Sprint(i), []byte("value"), time.Minute)
+			time.Sleep(time.Millisecond)
+		}
+		b.Get("0")
+		b.Add("3", []byte("value"), time.Minute)
+		if b.Get("0") == nil || b.Get("1") != nil {
+			t.Errorf("[%s] least recently used item should be evicted", name)
+		}
+
+		b = engine(&BulkConfig{MaxItem: 3, Eliminate: time.Second, EvictionPolicy: EvictLFU})
+		for i := 0; i < 3; i++ {
+			b.Add(fmt.Sprint(i), []byte("value"), time.Minute)
+		}
+		b.Get("0")
+		b.Get("2")
+		b.Add("3", []byte("value"), time.Minute)
+		if b.Get("1") != nil || b.Len() != 3 {
+			t.Errorf("[%s] least frequently used item should be evicted", name)
+		}
+
+		b = engine(&BulkConfig{MaxItem: 3, Eliminate: time.Second, EvictionPolicy: EvictRandom})
+		for i := 0; i < 10; i++ {
+			if err := b.Add(fmt.Sprint(i), []byte("value"), time.Minute); err != nil {
+				t.Errorf("[%s] random policy add error[%s]", name, err.Error())
+			}
+		}
+		if b.Len() != 3 || b.Analytics().Evictions != 7 {
+			t.Errorf("[%s] random policy should keep 3 items", name)
+		}
+	}
+}
+
+func Test_EvictionUnlimited(t *testing.T) {
+	for _, max := range []int{0, -1} {
+		for name, b := range map[string]Bulk{
+			HashEngine:  newHashBulk(&BulkConfig{MaxItem: max, Eliminate: time.Second}, Cached{}),
+			BTreeEngine: newBTreeBulk(&BulkConfig{MaxItem: max, Eliminate: time.Second}, Cached{}),
+		} {
+			for i := 0; i < 10; i++ {
+				if err := b.Add(fmt.Sprint(i), []byte("value"), time.Minute); err != nil {
+					t.Errorf("[%s] max item %d should be unlimited, error[%s]", name, max, err.Error())
+				}
+			}
+		}
+	}
+}
+
+func Test_EvictOldestExpireExact(t *testing.T) {
+	engines := map[string]func(*BulkConfig) Bulk{
+		HashEngine:    func(cfg *BulkConfig) Bulk { return NewHashBulk(cfg) },
+		WheelEngine:   func(cfg *BulkConfig) Bulk { return NewWheelBulk(cfg) },
+		ShardedEngine: func(cfg *BulkConfig) Bulk { return NewShardedBulk(cfg) },
+	}
+	for name, engine := range engines {
+		b := engine(&BulkConfig{MaxItem: 100, Eliminate: time.Second, EvictionPolicy: EvictOldestExpire, Shards: 4})
+		if name != ShardedEngine {
+			b = engine(&BulkConfig{MaxItem: 100, Eliminate: time.Second, EvictionPolicy: EvictOldestExpire})
+		}
+		for i := 0; i < 100; i++ {
+			b.Add(fmt.Sprint(i), []byte("value"), time.Hour+time.Minute*time.Duration(i))
+		}
+		//overwrites move items in expire order
+		b.Add("0", []byte("value"), time.Hour*3)
+		for i := 100; i < 150; i++ {
+			b.Add(fmt.Sprint(i), []byte("value"), time.Hour*2)
+		}
+		for i := 1; i <= 50; i++ {
+			if b.Get(fmt.Sprint(i)) != nil {
+				t.Errorf("[%s] %d is kept while it expires before others", name, i)
+			}
+		}
+		if b.Get("0") == nil || b.Get("51") == nil || b.Len() != 100 {
+			t.Errorf("[%s] items expiring later are evicted, len %d", name, b.Len())
+		}
+	}
+}
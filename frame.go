@@ -0,0 +1,189 @@
+package bulkCache
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Binary Dage protocol
+//
+// A client starts the binary protocol by sending BinaryMagic followed by
+// the highest version it speaks, the server answers BinaryMagic followed
+// by the version both sides use, version 0 means the server refuses.
+// A connection starting with any other byte speaks the text protocol.
+//
+// After the handshake both sides exchange frames
+//	[u32 length][u8 code][u64 tick][u32 len][field]...[u32 len][field]
+// length counts every byte after itself, code is an opcode in requests
+// and a status in responses, the response of a request has the same tick.
+// Integer fields are 8 bytes big endian, times are unix milliseconds.
+//
+//	PING                                  => OK
+//	SET bulk key value expire_ms          => OK or Failure
+//	GET bulk                              => OK value... or NotFound
+//	REMOVE bulk                           => OK
+//	RANGE bulk from_ms to_ms limit        => OK value expire_ms ... or NotFound
+//	SCAN bulk cursor count                => OK next_cursor value... or NotFound
+//	SUBSCRIBE bulk [add,expire,evict,...] => OK, then Event frames of the tick
+//	                                         type bulk key value expire_ms
+//	UNSUBSCRIBE [bulk]                    => OK
+//	QUIT                                  => OK, then server closes connection
+//	MSET bulk key value expire_ms ...     => OK status... one status byte per item
+//	MGET bulk...                          => OK count value... per bulk, count -1 if not found
+//	MREMOVE bulk...                       => OK
+//	AUTH token                            => OK or Failure
+//	SLOWLOG [GET [count]]                 => OK id time_ms command bulk size duration_us client ...
+//	SLOWLOG LEN                           => OK count
+//	SLOWLOG RESET                         => OK
+//	CREATE bulk maxitem eliminate_ms [eviction_policy [shards]]
+//	                                      => OK or Failure error
+//	SELECT container                      => OK or NotFound
+// Commands other than PING, AUTH and QUIT get Denied without permission of token.
+// Bad parameters get BadRequest, unknown opcodes get Unknown.
+
+const (
+	BinaryMagic   = "\x00DAGE"
+	BinaryVersion = 1
+
+	frameHeader = 1 + 8 //code and tick
+)
+
+//opcodes
+const (
+	OpPing byte = iota + 1
+	OpSet
+	OpGet
+	OpRemove
+	OpRange
+	OpScan
+	OpSubscribe
+	OpUnsubscribe
+	OpQuit
+	OpMSet
+	OpMGet
+	OpMRemove
+	OpAuth
+	OpSlowLog
+	OpCreate
+	OpSelect
+)
+
+//status codes
+const (
+	StatusOK byte = iota
+	StatusFailure
+	StatusNotFound
+	StatusBadRequest
+	StatusUnknown
+	StatusEvent
+	StatusDenied
+)
+
+var (
+	MaxFrameSize = 64 << 20 //64MB, also limits a line of text protocol
+
+	ErrFrameTooLarge = errors.New("Frame exceeds max frame size")
+	ErrBadFrame      = errors.New("Malformed frame")
+	ErrHandshake     = errors.New("Binary protocol handshake failed")
+)
+
+type (
+	Frame struct {
+		Code   byte //opcode of request or status of response
+		Tick   uint64
+		Fields [][]byte
+	}
+)
+
+func NewFrame(code byte, tick uint64, fields ...[]byte) *Frame {
+	return &Frame{Code: code, Tick: tick, Fields: fields}
+}
+
+// Bytes encodes frame, a frame is written by one Write call
+func (f *Frame) Bytes() []byte {
+	n := frameHeader
+	for _, field := range f.Fields {
+		n += 4 + len(field)
+	}
+	b := make([]byte, 4+n)
+	binary.BigEndian.PutUint32(b, uint32(n))
+	b[4] = f.Code
+	binary.BigEndian.PutUint64(b[5:], f.Tick)
+	p := 4 + frameHeader
+	for _, field := range f.Fields {
+		binary.BigEndian.PutUint32(b[p:], uint32(len(field)))
+		p += 4
+		p += copy(b[p:], field)
+	}
+	return b
+}
+
+// ReadFrame reads a frame from r, io.EOF means the peer closed between frames
+func ReadFrame(r io.Reader) (*Frame, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(head)
+	if n < frameHeader {
+		return nil, ErrBadFrame
+	}
+	if int64(n) > int64(MaxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	f := &Frame{Code: b[0], Tick: binary.BigEndian.Uint64(b[1:]), Fields: [][]byte{}}
+	b = b[frameHeader:]
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, ErrBadFrame
+		}
+		l := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint64(l) > uint64(len(b)) {
+			return nil, ErrBadFrame
+		}
+		f.Fields = append(f.Fields, b[:l:l])
+		b = b[l:]
+	}
+	return f, nil
+}
+
+// Int returns integer field i
+func (f *Frame) Int(i int) (int64, error) {
+	if i >= len(f.Fields) || len(f.Fields[i]) != 8 {
+		return 0, ErrBadFrame
+	}
+	return int64(binary.BigEndian.Uint64(f.Fields[i])), nil
+}
+
+// IntField encodes n as an integer field
+func IntField(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+// handshake is BinaryMagic followed by version
+func handshake(version byte) []byte {
+	return append([]byte(BinaryMagic), version)
+}
+
+// readHandshake reads the handshake of peer and returns its version
+func readHandshake(r io.Reader) (byte, error) {
+	b := make([]byte, len(BinaryMagic)+1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	if string(b[:len(BinaryMagic)]) != BinaryMagic {
+		return 0, ErrHandshake
+	}
+	return b[len(BinaryMagic)], nil
+}
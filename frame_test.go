@@ -0,0 +1,129 @@
+package bulkCache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Frame(t *testing.T) {
+	f := NewFrame(OpSet, 42, []byte("Video"), []byte{}, []byte("a\tb\nc"), IntField(-1500))
+	g, err := ReadFrame(bytes.NewReader(f.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Code != OpSet || g.Tick != 42 || len(g.Fields) != 4 || string(g.Fields[2]) != "a\tb\nc" {
+		t.Errorf("decode frame %v", g)
+	}
+	if n, err := g.Int(3); err != nil || n != -1500 {
+		t.Errorf("int field %d error[%v]", n, err)
+	}
+	if _, err := g.Int(0); err != ErrBadFrame {
+		t.Error("short int field should be bad")
+	}
+
+	b := f.Bytes()
+	if _, err := ReadFrame(bytes.NewReader(b[:len(b)-1])); err == nil {
+		t.Error("truncated frame should fail")
+	}
+	b[len(b)-9] = 0xff //length of last field
+	if _, err := ReadFrame(bytes.NewReader(b)); err != ErrBadFrame {
+		t.Errorf("corrupt field length error[%v]", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})); err != ErrFrameTooLarge {
+		t.Errorf("huge frame error[%v]", err)
+	}
+}
+
+func Test_DageBinary(t *testing.T) {
+	d := newTestDage()
+	defer d.Listener.Close()
+	c, err := Dial(d.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	big := bytes.Repeat([]byte("\t\n\x00"), 100<<10)
+	if err := c.Set(ctx, "Binary Video", "big", big, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	values, err := c.Get(ctx, "Binary Video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || !bytes.Equal(values[0], big) {
+		t.Errorf("get %d values, want the big value", len(values))
+	}
+
+	now := time.Now()
+	f, err := c.Call(ctx, OpRange, []byte("Binary Video"), IntField(milli(now)), IntField(milli(now.Add(time.Hour))), IntField(0))
+	if err != nil || f.Code != StatusOK || len(f.Fields) != 2 {
+		t.Fatalf("range get %v error[%v]", f, err)
+	}
+	if ex, _ := f.Int(1); ex < milli(now.Add(time.Second*59)) {
+		t.Errorf("range expire %d", ex)
+	}
+	if f, _ := c.Call(ctx, OpScan, []byte("Not Found"), []byte(ScanStart), IntField(10)); f.Code != StatusNotFound {
+		t.Errorf("scan missing bulk get status %d", f.Code)
+	}
+	if f, _ := c.Call(ctx, OpSet, []byte("Binary Video")); f.Code != StatusBadRequest {
+		t.Errorf("set without value get status %d", f.Code)
+	}
+	c.Remove(ctx, "Binary Video")
+}
+
+func Test_DageBinaryEvents(t *testing.T) {
+	d := newTestDage()
+	defer d.Listener.Close()
+	conn, err := net.Dial("tcp", d.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	conn.Write(handshake(BinaryVersion + 1))
+	r := bufio.NewReader(conn)
+	if v, err := readHandshake(r); err != nil || v != BinaryVersion {
+		t.Fatalf("handshake version %d error[%v]", v, err)
+	}
+	conn.Write(NewFrame(OpSubscribe, 7, []byte("Binary Events"), []byte("add")).Bytes())
+	if f, err := ReadFrame(r); err != nil || f.Code != StatusOK || f.Tick != 7 {
+		t.Fatalf("subscribe get %v error[%v]", f, err)
+	}
+	d.Containers.Default().Add("Binary Events", "k", []byte("a\tb"), time.Minute)
+	f, err := ReadFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Code != StatusEvent || f.Tick != 7 || len(f.Fields) != 5 || string(f.Fields[0]) != "add" || string(f.Fields[3]) != "a\tb" {
+		t.Errorf("event frame %v", f)
+	}
+}
+
+func Test_DageTextLongLine(t *testing.T) {
+	d := newTestDage()
+	defer d.Listener.Close()
+	conn, err := net.Dial("tcp", d.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	big := strings.Repeat("v", 100<<10)
+	fmt.Fprintf(conn, "1\tSET\tLong Video\tk\t%s\t60\n", big)
+	r := bufio.NewReader(conn)
+	if l, err := r.ReadString('\n'); err != nil || l != "1 Success \n" {
+		t.Fatalf("set long line get %q error[%v]", l, err)
+	}
+	fmt.Fprint(conn, "2\tGET\tLong Video\n")
+	if l, err := r.ReadString('\n'); err != nil || len(l) != len(big)+4 {
+		t.Errorf("get long line %d bytes error[%v]", len(l), err)
+	}
+}
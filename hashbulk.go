@@ -1,11 +1,13 @@
 package bulkCache
 
 import (
-	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/emirpasic/gods/trees/btree"
 )
 
 type (
@@ -14,7 +16,13 @@ type (
 		analytics *Analytics
 		config    *BulkConfig
 		cache     Cached
+		index     *btree.Tree //key => item, ordered for Scan
+		expires   *expireHeap //keys by expire for oldest expire eviction
+		count     *int64      //items counted against MaxItem, shared by shards of a ShardedBulk
+		handler   EventHandler
 		stop      bool
+		//runs eliminate pass on the wheel of container, nil runs Eliminate
+		eliminator *eliminator
 	}
 )
 
@@ -27,53 +35,199 @@ func NewDefaultHashBulkConfig() *BulkConfig {
 }
 
 func NewHashBulk(cfg *BulkConfig) *HashBulk {
+	return NewHashBulkFromCached(cfg, Cached{})
+}
+
+func NewHashBulkFromCached(cfg *BulkConfig, cached Cached) *HashBulk {
+	bulk := newHashBulk(cfg, cached)
+	go bulk.Eliminate()
+	return bulk
+}
+
+// newHashBulk creates a hash bulk without eliminate goroutine
+func newHashBulk(cfg *BulkConfig, cached Cached) *HashBulk {
 	if cfg == nil {
 		cfg = NewDefaultHashBulkConfig()
 	}
-	bulk := &HashBulk{
+	b := &HashBulk{
 		Mut:       &sync.RWMutex{},
 		analytics: NewAnalytics(),
 		config:    cfg,
 		cache:     Cached{},
+		index:     btree.NewWithStringComparator(3),
+		expires:   newExpireHeap(),
+		count:     new(int64),
 	}
-	go bulk.Eliminate()
-	return bulk
+	*b.count = int64(len(cached))
+	for k, i := range cached {
+		b.put(k, i)
+		b.analytics.store(k, i.Data)
+	}
+	return b
 }
 
-func NewHashBulkFromCached(cfg *BulkConfig, cached Cached) *HashBulk {
-	b := NewHashBulk(cfg)
-	b.cache = cached
-	b.Mut = &sync.RWMutex{}
-	b.analytics = NewAnalytics()
-	return b
+func (b *HashBulk) OnEvent(handler EventHandler) {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	b.handler = handler
+}
+
+// notify reports an event of item, caller must hold the lock
+func (b *HashBulk) notify(t EventType, key string, i *Item) {
+	if b.handler != nil {
+		b.handler(t, key, i)
+	}
 }
 
 func (b *HashBulk) Config() *BulkConfig {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
 	return b.config
 }
 
+// SetConfig replaces config, items over MaxItem are evicted
+func (b *HashBulk) SetConfig(cfg *BulkConfig) error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	b.config = cfg
+	policy := cfg.EvictionPolicy
+	if policy == EvictReject {
+		policy = EvictOldestExpire
+	}
+	for cfg.over(len(b.cache)) {
+		if _, ok := b.evictBy(policy); !ok {
+			break
+		}
+	}
+	return nil
+}
+
 func (b *HashBulk) Analytics() *Analytics {
 	return b.analytics
 }
 
 // expired by pre nanosecond
 func (b *HashBulk) Add(key string, value []byte, expire time.Duration) error {
-	if b.Len() > b.config.MaxItem {
-		return errors.New("Bulk is fulled")
-	}
+	return b.AddAt(key, value, time.Now().Add(expire))
+}
+
+// AddAt puts an item expiring at expire
+func (b *HashBulk) AddAt(key string, value []byte, expire time.Time) error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	return b.add(key, value, expire)
+}
+
+// AddMany adds items under one lock, errors are in order of items
+func (b *HashBulk) AddMany(items []BatchItem) []error {
 	b.Mut.Lock()
-	defer b.Analytics().Add(value)
 	defer b.Mut.Unlock()
-	f := time.Now().Add(expire)
-	i := &Item{Data: value, Expire: f}
+	errs := make([]error, len(items))
+	now := time.Now()
+	for n, i := range items {
+		errs[n] = b.add(i.Key, i.Value, i.deadline(now))
+	}
+	return errs
+}
+
+// add puts an item expiring at expire, caller must hold the lock
+func (b *HashBulk) add(key string, value []byte, expire time.Time) error {
+	old, ok := b.cache[key]
+	for !ok && !b.reserve() {
+		if err := b.evict(); err != nil {
+			return err
+		}
+	}
+	if ok {
+		b.analytics.Removed(key, old.Data)
+	}
+	b.put(key, NewItem(value, expire))
+	b.analytics.Add(key, value)
+	return nil
+}
+
+// reserve counts a new item against MaxItem, it returns false if the bulk is full
+func (b *HashBulk) reserve() bool {
+	n := atomic.AddInt64(b.count, 1)
+	if b.config.over(int(n)) {
+		atomic.AddInt64(b.count, -1)
+		return false
+	}
+	return true
+}
+
+// put stores the item of key in cache and index, caller must hold the lock
+func (b *HashBulk) put(key string, i *Item) {
 	b.cache[key] = i
+	b.index.Put(key, i)
+	b.expires.set(key, i.Expire)
+}
+
+// drop removes the item of key from cache, caller must hold the lock
+func (b *HashBulk) drop(key string) {
+	delete(b.cache, key)
+	b.index.Remove(key)
+	b.expires.remove(key)
+	atomic.AddInt64(b.count, -1)
+}
+
+// evict removes one item by eviction policy of config, caller must hold the lock
+func (b *HashBulk) evict() error {
+	if b.config.EvictionPolicy == EvictReject {
+		return ErrBulkFull
+	}
+	if _, ok := b.evictBy(b.config.EvictionPolicy); !ok {
+		return ErrBulkFull
+	}
 	return nil
 }
 
+// evictBy removes one item by policy and returns bytes it frees, caller must hold the lock
+func (b *HashBulk) evictBy(policy EvictionPolicy) (int, bool) {
+	var key string
+	if policy == EvictOldestExpire {
+		var ok bool
+		if key, _, ok = b.expires.first(); !ok {
+			return 0, false
+		}
+	} else {
+		samples := make([]evictionCandidate, 0, EvictionSamples)
+		for k, i := range b.cache {
+			samples = append(samples, evictionCandidate{key: k, item: i})
+			if len(samples) == EvictionSamples || policy == EvictRandom {
+				break
+			}
+		}
+		var ok bool
+		if key, ok = selectVictim(policy, samples); !ok {
+			return 0, false
+		}
+	}
+	i := b.cache[key]
+	b.analytics.Evict(key, i.Data)
+	b.drop(key)
+	b.notify(EventEvict, key, i)
+	return itemBytes(key, i), true
+}
+
+// firstExpire returns when the item expiring first expires
+func (b *HashBulk) firstExpire() (time.Time, bool) {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
+	_, ex, ok := b.expires.first()
+	return ex, ok
+}
+
+func (b *HashBulk) Evict(policy EvictionPolicy) (int, bool) {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	return b.evictBy(policy)
+}
+
 func (b *HashBulk) Get(key string) *Item {
 	b.Mut.RLock()
 	defer b.Analytics().Get()
-	defer b.Mut.Unlock()
+	defer b.Mut.RUnlock()
 	i, ok := b.cache[key]
 	if !ok {
 		return nil
@@ -84,13 +238,47 @@ func (b *HashBulk) Get(key string) *Item {
 	if n.After(i.Expire) {
 		return nil
 	}
-
+	i.touch()
 	return i
 }
 
+func (b *HashBulk) Update(key string, value []byte, expire time.Duration) error {
+	return b.UpdateAt(key, value, time.Now().Add(expire))
+}
+
+// UpdateAt overwrites an alive item with one expiring at expire
+func (b *HashBulk) UpdateAt(key string, value []byte, expire time.Time) error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	return b.update(key, value, expire)
+}
+
+// update overwrites an alive item, caller must hold the lock
+func (b *HashBulk) update(key string, value []byte, expire time.Time) error {
+	i, ok := b.cache[key]
+	if !ok || time.Now().After(i.Expire) {
+		return ErrItemNotFound
+	}
+	b.analytics.Removed(key, i.Data)
+	b.analytics.Add(key, value)
+	b.put(key, NewItem(value, expire))
+	return nil
+}
+
+func (b *HashBulk) Delete(key string) bool {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	i, ok := b.cache[key]
+	if !ok {
+		return false
+	}
+	b.analytics.Removed(key, i.Data)
+	b.drop(key)
+	return true
+}
+
 func (b *HashBulk) GetAlive() Cached {
 	b.Mut.RLock()
-	defer b.Mut.RUnlock()
 	n := time.Now()
 	cached := Cached{}
 	es := []string{}
@@ -101,35 +289,47 @@ func (b *HashBulk) GetAlive() Cached {
 			es = append(es, k)
 		}
 	}
+	b.Mut.RUnlock()
 
-	for _, e := range es {
-		delete(b.cache, e)
+	//expired items are removed under write lock
+	if len(es) > 0 {
+		b.Mut.Lock()
+		for _, e := range es {
+			if i, ok := b.cache[e]; ok && n.After(i.Expire) {
+				b.analytics.Expired(e, i.Data)
+				b.drop(e)
+				b.notify(EventExpire, e, i)
+			}
+		}
+		b.Mut.Unlock()
 	}
 	return cached
 }
 
+func (b *HashBulk) Scan(cursor string, count int) ([]KeyItem, string) {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
+	return scanTree(b.index, cursor, count)
+}
+
 func (b *HashBulk) GetAliveInBulk() Bulk {
-	cached := b.GetAlive()
-	return &HashBulk{
-		config:    b.config,
-		Mut:       &sync.RWMutex{},
-		analytics: NewAnalytics(),
-		cache:     cached,
-	}
+	return newHashBulk(b.Config(), b.GetAlive())
 }
 
 func (b *HashBulk) Len() int {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
 	return len(b.cache)
 }
 
-func (b *HashBulk) Bytes() (n int) {
-	for k, i := range b.cache {
-		n += len(i.Data) + len(k)
-	}
-	return
+// Bytes returns bytes of sub keys and values accounted by analytics
+func (b *HashBulk) Bytes() int {
+	return int(b.analytics.Bytes())
 }
 
 func (b *HashBulk) String() string {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
 	s := []string{"**********Hash BULK**********"}
 	for _, v := range b.cache {
 		s = append(s, fmt.Sprintf("------[%v]@[%s]------", v.Data, v.Expire.String()))
@@ -138,21 +338,45 @@ func (b *HashBulk) String() string {
 }
 
 func (b *HashBulk) Eliminate() {
-	for !b.stop {
-		<-time.After(b.config.Eliminate)
-		n := time.Now()
-		ks := []string{}
-		for k, v := range b.cache {
-			if n.After(v.Expire) {
-				ks = append(ks, k)
-			}
+	for {
+		<-time.After(b.Config().Eliminate)
+		if !b.eliminate(time.Now()) {
+			return
 		}
-		for _, p := range ks {
-			delete(b.cache, p)
+	}
+}
+
+// eliminate removes items expired at n, it returns false once bulk is stopped
+func (b *HashBulk) eliminate(n time.Time) bool {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	if b.stop {
+		return false
+	}
+	for k, v := range b.cache {
+		if n.After(v.Expire) {
+			b.analytics.Expired(k, v.Data)
+			b.drop(k)
+			b.notify(EventExpire, k, v)
 		}
 	}
+	return true
+}
+
+// SetScheduler runs the eliminate pass of b on w instead of its own goroutine
+func (b *HashBulk) SetScheduler(w *TimingWheel) {
+	e := newEliminator(w, b.eliminate, func() time.Duration { return b.Config().Eliminate })
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	b.eliminator = e
 }
 
 func (b *HashBulk) Stop() {
+	b.Mut.Lock()
 	b.stop = true
+	e := b.eliminator
+	b.Mut.Unlock()
+	if e != nil {
+		e.stop()
+	}
 }
@@ -1,12 +1,21 @@
 package bulkCache
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine"
 	"github.com/labstack/echo/engine/fasthttp"
 )
 
@@ -17,34 +26,150 @@ type (
 		Handler *echo.Echo
 		Engine  *fasthttp.Server
 		Log     *log.Entry
+		//bearer tokens required by every route, nil lets everyone in
+		Auth *Auth
+		//serves https if not nil
+		TLS *tls.Config
+		//containers of /c/:container routes, other routes use the default one
+		Containers *Registry
+		//commands and slow log of it are reported by /metrics and /status/slowlog if not nil
+		Dage *Dage
+	}
+
+	// BatchOp is an operation of POST /batch, Op is set, get, delete or remove,
+	// delete removes the item of key and remove removes the whole bulk
+	BatchOp struct {
+		Op     string `json:"op"`
+		Bulk   string `json:"bulk"`
+		Key    string `json:"key"`
+		Value  string `json:"value"`
+		Expire int    `json:"expire"` //seconds
+	}
+
+	// HttpBulkConfig is the body of PUT /bulk/:id/config and GET /bulk/:id/config
+	HttpBulkConfig struct {
+		MaxItem        int    `json:"max_item"` //0 or negative means unlimited
+		Eliminate      int64  `json:"eliminate_ms"`
+		EvictionPolicy string `json:"eviction_policy"` //reject by default
+		Shards         int    `json:"shards"`
+	}
+
+	// errWriter keeps the first write error
+	errWriter struct {
+		w   io.Writer
+		err error
 	}
 )
 
 var (
-	HttpApi *EchoHttpServer
+	HttpApi *EchoHttpServer //http server of Default, set up by Defaults
 )
 
-func NewEchoHttpServer() *EchoHttpServer {
-	return &EchoHttpServer{
-		Handler: echo.New(),
+// NewEchoHttpServer creates a http server of c with every route registered,
+// containers added to Containers are served under /c/:container
+func NewEchoHttpServer(c *Container) *EchoHttpServer {
+	h := &EchoHttpServer{
+		Handler:    echo.New(),
+		Containers: NewRegistry(c),
 		Log: log.WithFields(log.Fields{
 			"Api": "Http power by echo",
 		}),
 	}
+	h.Handler.Use(h.Authenticate)
+	h.Handler.GET("/metrics", h.Metrics)
+	h.Handler.GET("/status/slowlog", h.SlowLog)
+	//routes of the default container, and of any container under /c/:container
+	h.containerRoutes(h.Handler.Group(""))
+	h.containerRoutes(h.Handler.Group("/c/:container", h.SelectContainer))
+	return h
 }
 
 func (h *EchoHttpServer) Listen(port string) {
-	h.Engine = fasthttp.New(port)
+	if h.TLS == nil {
+		h.Engine = fasthttp.New(port)
+	} else {
+		//engine serves the TLS listener as is, so client certificates are verified by h.TLS
+		l, err := listen(port, h.TLS)
+		if err != nil {
+			h.Log.Error(fmt.Sprintf("Listen https api server on %s error[%s]", port, err.Error()))
+			os.Exit(1)
+		}
+		h.Engine = fasthttp.WithConfig(engine.Config{Address: port, Listener: l})
+	}
 	h.Log.Info("Start http api server on " + port)
 	h.Handler.Run(h.Engine)
 }
 
+// Authenticate is a middleware checking "Authorization: Bearer token" against h.Auth
+func (h *EchoHttpServer) Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		if h.Auth == nil {
+			return next(ctx)
+		}
+		secret := ctx.Request().Header().Get("Authorization")
+		if !strings.HasPrefix(secret, "Bearer ") {
+			return ctx.JSON(401, Data{"result": 1, "error": ErrUnauthorized.Error()})
+		}
+		t, err := h.Auth.Authenticate(strings.TrimPrefix(secret, "Bearer "))
+		if err != nil {
+			h.Log.Warning(fmt.Sprintf("Client %s auth failed", ctx.Request().RemoteAddress()))
+			return ctx.JSON(401, Data{"result": 1, "error": err.Error()})
+		}
+		ctx.Set("token", t)
+		return next(ctx)
+	}
+}
+
+// permit checks whether token of request may change bulks
+func (h *EchoHttpServer) permit(ctx echo.Context, bulks ...string) error {
+	t, _ := ctx.Get("token").(*Token)
+	return h.Auth.Permit(t, true, bulks...)
+}
+
+// SelectContainer is a middleware choosing the container of :container param
+func (h *EchoHttpServer) SelectContainer(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		db, ok := h.Containers.Get(ctx.Param("container"))
+		if !ok {
+			return ctx.JSON(200, Data{"result": 1, "error": ErrContainerNotFound.Error()})
+		}
+		ctx.Set("container", db)
+		return next(ctx)
+	}
+}
+
+// container returns the container of request, the default one without SelectContainer
+func (h *EchoHttpServer) container(ctx echo.Context) *Container {
+	if db, ok := ctx.Get("container").(*Container); ok {
+		return db
+	}
+	return h.Containers.Default()
+}
+
+// parseTime parses unix seconds or RFC3339 time, empty string returns def
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 func (h *EchoHttpServer) GetBulkItems(ctx echo.Context) error {
+	db := h.container(ctx)
 	bulk := ctx.Param("id")
 	if bulk == "" {
 		return ctx.JSON(200, Data{"result": 1})
 	}
-	its, ok := Default.Get(bulk)
+	if ctx.QueryParam("from") != "" || ctx.QueryParam("to") != "" || ctx.QueryParam("limit") != "" {
+		return h.RangeBulkItems(ctx)
+	}
+	if ctx.QueryParam("cursor") != "" || ctx.QueryParam("count") != "" {
+		return h.ScanBulkItems(ctx)
+	}
+	its, ok := db.Get(bulk)
 	if !ok {
 		h.Log.Warning(fmt.Sprintf("Bulk %s is empty", bulk))
 		return ctx.JSON(200, Data{"result": 1})
@@ -59,15 +184,151 @@ func (h *EchoHttpServer) GetBulkItems(ctx echo.Context) error {
 	return ctx.JSON(200, Data{"result": 0, "items": items})
 }
 
+// RangeBulkItems responses items expiring in [from, to] ordered by expire,
+// from defaults to now, to defaults to no limit
+func (h *EchoHttpServer) RangeBulkItems(ctx echo.Context) error {
+	db := h.container(ctx)
+	bulk := ctx.Param("id")
+	from, err := parseTime(ctx.QueryParam("from"), time.Now())
+	if err != nil {
+		h.Log.Error(fmt.Sprintf("Invalid from[%s]", ctx.QueryParam("from")))
+		return ctx.JSON(200, Data{"result": 1})
+	}
+	to, err := parseTime(ctx.QueryParam("to"), time.Unix(1<<62, 0))
+	if err != nil {
+		h.Log.Error(fmt.Sprintf("Invalid to[%s]", ctx.QueryParam("to")))
+		return ctx.JSON(200, Data{"result": 1})
+	}
+	limit := 0
+	if l := ctx.QueryParam("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			h.Log.Error(fmt.Sprintf("Invalid limit[%s]", l))
+			return ctx.JSON(200, Data{"result": 1})
+		}
+	}
+	its, err := db.Range(bulk, from, to, limit)
+	if err != nil {
+		h.Log.Warning(fmt.Sprintf("Bulk %s is empty", bulk))
+		return ctx.JSON(200, Data{"result": 1})
+	}
+	items := []string{}
+	expires := []int64{}
+	for _, i := range its {
+		items = append(items, string(i.Data))
+		expires = append(expires, i.Expire.Unix())
+	}
+	h.Log.Info(fmt.Sprintf("From Bulk %s Range %d items", bulk, len(items)))
+	return ctx.JSON(200, Data{"result": 0, "items": items, "expires": expires})
+}
+
+// ScanBulkItems responses a page of items and the cursor of next page,
+// scan starts with cursor 0 and ends when cursor 0 is returned
+func (h *EchoHttpServer) ScanBulkItems(ctx echo.Context) error {
+	db := h.container(ctx)
+	bulk := ctx.Param("id")
+	count := 0
+	if c := ctx.QueryParam("count"); c != "" {
+		var err error
+		if count, err = strconv.Atoi(c); err != nil {
+			h.Log.Error(fmt.Sprintf("Invalid count[%s]", c))
+			return ctx.JSON(200, Data{"result": 1})
+		}
+	}
+	its, next, err := db.Scan(bulk, ctx.QueryParam("cursor"), count)
+	if err != nil {
+		h.Log.Warning(fmt.Sprintf("Scan bulk %s error[%s]", bulk, err.Error()))
+		return ctx.JSON(200, Data{"result": 1})
+	}
+	items := []string{}
+	for _, i := range its {
+		items = append(items, string(i.Data))
+	}
+	return ctx.JSON(200, Data{"result": 0, "items": items, "cursor": next})
+}
+
+// BulkEvents streams events of bulk as server-sent events until client leaves,
+// events query selects add,expire,evict,remove and defaults to all
+func (h *EchoHttpServer) BulkEvents(ctx echo.Context) error {
+	db := h.container(ctx)
+	bulk := ctx.Param("id")
+	events, err := ParseEventTypes(ctx.QueryParam("events"))
+	if err != nil {
+		h.Log.Error(err.Error())
+		return ctx.JSON(200, Data{"result": 1})
+	}
+	res := ctx.Response()
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+
+	sub := db.Subscribe(bulk, events)
+	h.Log.Info(fmt.Sprintf("Client %s subscribes %s of bulk %s", ctx.Request().RemoteAddress(), events.String(), bulk))
+	stream := func(w io.Writer, flush func() error) {
+		defer sub.Close()
+		for {
+			select {
+			case e := <-sub.C:
+				data, _ := json.Marshal(Data{
+					"bulk":   e.Bulk,
+					"key":    TrimKey(e.Key),
+					"value":  string(e.Data),
+					"expire": e.Expire.Unix(),
+				})
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type.String(), data)
+			case <-time.After(time.Second * 15):
+				//keep alive, also finds out left clients
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			if err := flush(); err != nil {
+				return
+			}
+		}
+	}
+
+	//fasthttp streams body by a writer running after handler returns
+	if r, ok := res.(*fasthttp.Response); ok {
+		r.SetBodyStreamWriter(func(w *bufio.Writer) {
+			stream(w, w.Flush)
+		})
+		return nil
+	}
+	res.WriteHeader(200)
+	w := &errWriter{w: res.Writer()}
+	stream(w, func() error {
+		if f, ok := res.Writer().(http.Flusher); ok {
+			f.Flush()
+		}
+		return w.err
+	})
+	return nil
+}
+
+func (w *errWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(b)
+	w.err = err
+	return n, err
+}
+
 func (h *EchoHttpServer) DeleteBulk(ctx echo.Context) error {
+	db := h.container(ctx)
 	id := ctx.Param("id")
-	Default.Remove(id)
+	if err := h.permit(ctx, id); err != nil {
+		return ctx.JSON(403, Data{"result": 1, "error": err.Error()})
+	}
+	db.Remove(id)
 	h.Log.Info(fmt.Sprintf("Deleted Bulk %s", id))
 	return ctx.JSON(200, Data{"result": 0})
 }
 
 func (h *EchoHttpServer) SetItem(ctx echo.Context) error {
+	db := h.container(ctx)
 	id := ctx.Param("id")
+	if err := h.permit(ctx, id); err != nil {
+		return ctx.JSON(403, Data{"result": 1, "error": err.Error()})
+	}
 	name := ctx.FormValue("name")
 	value := ctx.FormValue("value")
 	ex := ctx.FormValue("expire")
@@ -76,23 +337,118 @@ func (h *EchoHttpServer) SetItem(ctx echo.Context) error {
 		h.Log.Error(fmt.Sprintf("Invalid expire[%s]", ex))
 		return ctx.JSON(200, Data{"result": 1})
 	}
-	Default.Add(id, name, []byte(value), time.Duration(expire)*time.Second)
+	db.Add(id, name, []byte(value), time.Duration(expire)*time.Second)
 	h.Log.Info(fmt.Sprintf("Add %d bytes to %s", len(value), id))
 	return ctx.JSON(200, Data{"result": 0})
 }
 
+// Batch runs a JSON array of operations in order and responses a result per operation,
+// adjacent set operations are added together by Container.AddMany
+func (h *EchoHttpServer) Batch(ctx echo.Context) error {
+	db := h.container(ctx)
+	ops := []BatchOp{}
+	if err := ctx.Bind(&ops); err != nil {
+		h.Log.Error(fmt.Sprintf("Invalid batch[%s]", err.Error()))
+		return ctx.JSON(200, Data{"result": 1})
+	}
+	results := make([]Data, len(ops))
+	for n := 0; n < len(ops); n++ {
+		op := ops[n]
+		if op.Op == "set" || op.Op == "delete" || op.Op == "remove" {
+			if err := h.permit(ctx, op.Bulk); err != nil {
+				results[n] = Data{"result": 1, "error": err.Error()}
+				continue
+			}
+		}
+		switch op.Op {
+		case "set":
+			items := []BatchItem{}
+			indexes := []int{}
+			end := n
+			for ; end < len(ops) && ops[end].Op == "set"; end++ {
+				o := ops[end]
+				if err := h.permit(ctx, o.Bulk); err != nil {
+					results[end] = Data{"result": 1, "error": err.Error()}
+					continue
+				}
+				items = append(items, BatchItem{Bulk: o.Bulk, Key: o.Key, Value: []byte(o.Value), Expire: time.Duration(o.Expire) * time.Second})
+				indexes = append(indexes, end)
+			}
+			for i, err := range db.AddMany(items) {
+				if _, ok := err.(*PersistError); ok {
+					results[indexes[i]] = Data{"result": 0, "error": err.Error()}
+				} else if err != nil {
+					results[indexes[i]] = Data{"result": 1, "error": err.Error()}
+				} else {
+					results[indexes[i]] = Data{"result": 0}
+				}
+			}
+			n = end - 1
+		case "get":
+			its, ok := db.Get(op.Bulk)
+			if !ok {
+				results[n] = Data{"result": 1}
+				continue
+			}
+			items := []string{}
+			for _, i := range its {
+				items = append(items, string(i.Data))
+			}
+			results[n] = Data{"result": 0, "items": items}
+		case "delete":
+			if db.DeleteItem(op.Bulk, op.Key) {
+				results[n] = Data{"result": 0}
+			} else {
+				results[n] = Data{"result": 1}
+			}
+		case "remove":
+			db.Remove(op.Bulk)
+			results[n] = Data{"result": 0}
+		default:
+			results[n] = Data{"result": 1, "error": fmt.Sprintf("Unknown op[%s]", op.Op)}
+		}
+	}
+	h.Log.Info(fmt.Sprintf("Batch %d operations", len(ops)))
+	return ctx.JSON(200, Data{"result": 0, "results": results})
+}
+
+// Metrics responses metrics of containers and Dage server in Prometheus text format
+func (h *EchoHttpServer) Metrics(ctx echo.Context) error {
+	b := &bytes.Buffer{}
+	if err := h.Containers.WriteMetrics(b); err != nil {
+		return err
+	}
+	if h.Dage != nil {
+		if err := h.Dage.WriteMetrics(b); err != nil {
+			return err
+		}
+	}
+	ctx.Response().Header().Set("Content-Type", MetricsContentType)
+	ctx.Response().WriteHeader(200)
+	_, err := ctx.Response().Write(b.Bytes())
+	return err
+}
+
 func (h *EchoHttpServer) ContainerStatus(ctx echo.Context) error {
+	db := h.container(ctx)
 	return ctx.JSON(200, Data{
 		"result": 0,
 		"status": Data{
-			"memory":  Default.Analytics.Memories,
-			"queries": Default.Analytics.Queries,
+			"memory":      db.Analytics.Memories,
+			"bytes":       db.Analytics.Bytes(),
+			"queries":     db.Analytics.Queries,
+			"hits":        db.Analytics.Hits,
+			"misses":      db.Analytics.Misses,
+			"evictions":   db.Analytics.Evictions,
+			"expirations": db.Analytics.Expirations,
+			"max_memory":  db.MaxMemory(),
 		},
 	})
 }
 
 func (h *EchoHttpServer) BulkStatus(ctx echo.Context) error {
-	bulk, ok := Default.GetBulk(ctx.Param("id"))
+	db := h.container(ctx)
+	bulk, ok := db.GetBulk(ctx.Param("id"))
 	if !ok {
 		return ctx.JSON(200, Data{
 			"result": 1,
@@ -101,23 +457,107 @@ func (h *EchoHttpServer) BulkStatus(ctx echo.Context) error {
 	return ctx.JSON(200, Data{
 		"result": 0,
 		"status": Data{
-			"memory":  bulk.Analytics().Memories,
-			"queries": bulk.Analytics().Queries,
+			"memory":      bulk.Analytics().Memories,
+			"bytes":       bulk.Analytics().Bytes(),
+			"queries":     bulk.Analytics().Queries,
+			"hits":        bulk.Analytics().Hits,
+			"misses":      bulk.Analytics().Misses,
+			"evictions":   bulk.Analytics().Evictions,
+			"expirations": bulk.Analytics().Expirations,
 		},
 	})
 }
 
-func init() {
-	HttpApi = NewEchoHttpServer()
-	api := HttpApi.Handler.Group("/bulk")
+// SetBulkConfig creates bulk by config of body or changes its config
+func (h *EchoHttpServer) SetBulkConfig(ctx echo.Context) error {
+	db := h.container(ctx)
+	id := ctx.Param("id")
+	if err := h.permit(ctx, id); err != nil {
+		return ctx.JSON(403, Data{"result": 1, "error": err.Error()})
+	}
+	body := HttpBulkConfig{}
+	if err := ctx.Bind(&body); err != nil {
+		return ctx.JSON(200, Data{"result": 1, "error": err.Error()})
+	}
+	cfg := &BulkConfig{MaxItem: body.MaxItem, Eliminate: time.Duration(body.Eliminate) * time.Millisecond, Shards: body.Shards}
+	if body.EvictionPolicy != "" {
+		policy, err := ParseEvictionPolicy(body.EvictionPolicy)
+		if err != nil {
+			return ctx.JSON(200, Data{"result": 1, "error": err.Error()})
+		}
+		cfg.EvictionPolicy = policy
+	}
+	if _, err := db.SetBulkConfig(id, cfg); err != nil {
+		return ctx.JSON(200, Data{"result": 1, "error": err.Error()})
+	}
+	return ctx.JSON(200, Data{"result": 0})
+}
+
+func (h *EchoHttpServer) GetBulkConfig(ctx echo.Context) error {
+	db := h.container(ctx)
+	cfg, ok := db.BulkConfig(ctx.Param("id"))
+	if !ok {
+		return ctx.JSON(200, Data{"result": 1, "error": ErrBulkNotFound.Error()})
+	}
+	return ctx.JSON(200, Data{
+		"result": 0,
+		"config": HttpBulkConfig{
+			MaxItem:        cfg.MaxItem,
+			Eliminate:      int64(cfg.Eliminate / time.Millisecond),
+			EvictionPolicy: cfg.EvictionPolicy.String(),
+			Shards:         cfg.Shards,
+		},
+	})
+}
+
+// SlowLog responses latest slow commands newest first, count defaults to all
+func (h *EchoHttpServer) SlowLog(ctx echo.Context) error {
+	n := 0
+	if c := ctx.QueryParam("count"); c != "" {
+		var err error
+		if n, err = strconv.Atoi(c); err != nil {
+			return ctx.JSON(200, Data{"result": 1, "error": fmt.Sprintf("Invalid count[%s]", c)})
+		}
+	}
+	if h.Dage == nil || h.Dage.SlowLog == nil {
+		return ctx.JSON(200, Data{"result": 1, "error": "Slow log is disabled"})
+	}
+	slow := h.Dage.SlowLog
+	entries := []Data{}
+	for _, e := range slow.Entries(n) {
+		entries = append(entries, Data{
+			"id":          e.ID,
+			"time":        e.Time.UnixNano() / int64(time.Millisecond),
+			"command":     e.Command,
+			"bulk":        e.Bulk,
+			"size":        e.Size,
+			"duration_us": int64(e.Duration / time.Microsecond),
+			"client":      e.Client,
+		})
+	}
+	return ctx.JSON(200, Data{
+		"result":       0,
+		"threshold_us": int64(slow.Threshold() / time.Microsecond),
+		"len":          slow.Len(),
+		"entries":      entries,
+	})
+}
+
+// containerRoutes registers routes of a container under g
+func (h *EchoHttpServer) containerRoutes(g *echo.Group) {
+	api := g.Group("/bulk")
 	{
-		api.GET("/:id", HttpApi.GetBulkItems)
-		api.DELETE("/:id", HttpApi.DeleteBulk)
-		api.POST("/:id", HttpApi.SetItem)
+		api.GET("/:id", h.GetBulkItems)
+		api.GET("/:id/events", h.BulkEvents)
+		api.GET("/:id/config", h.GetBulkConfig)
+		api.PUT("/:id/config", h.SetBulkConfig)
+		api.DELETE("/:id", h.DeleteBulk)
+		api.POST("/:id", h.SetItem)
 	}
-	status := HttpApi.Handler.Group("/status")
+	g.POST("/batch", h.Batch)
+	status := g.Group("/status")
 	{
-		status.GET("/", HttpApi.ContainerStatus)
-		status.GET("/:id", HttpApi.BulkStatus)
+		status.GET("/", h.ContainerStatus)
+		status.GET("/:id", h.BulkStatus)
 	}
 }
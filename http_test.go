@@ -0,0 +1,261 @@
+package bulkCache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/test"
+)
+
+// sseWriter passes every write to C until it is closed, then fails them
+type sseWriter struct {
+	C      chan string
+	closed int32
+}
+
+func (w *sseWriter) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&w.closed) == 1 {
+		return 0, io.ErrClosedPipe
+	}
+	w.C <- string(b)
+	return len(b), nil
+}
+
+// serveHttp runs a request through routes of h and decodes its JSON response
+func serveHttp(h *EchoHttpServer, method, target, body string) Data {
+	return serveHttpAs(h, "", method, target, body)
+}
+
+// serveHttpAs is serveHttp by bearer token, empty sends no token,
+// a body is sent as JSON which echo binds
+func serveHttpAs(h *EchoHttpServer, token, method, target, body string) Data {
+	req := test.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if token != "" {
+		req.Header().Set("Authorization", "Bearer "+token)
+	}
+	rec := test.NewResponseRecorder()
+	h.Handler.ServeHTTP(req, rec)
+	res := Data{}
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	return res
+}
+
+// httpItems returns items of a response as strings
+func httpItems(res Data) []string {
+	its, _ := res["items"].([]interface{})
+	items := []string{}
+	for _, i := range its {
+		items = append(items, fmt.Sprint(i))
+	}
+	return items
+}
+
+func Test_HttpRangeBulkItems(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine} {
+		c, _ := NewContainer("Http Range", engine)
+		h := NewEchoHttpServer(c)
+		n := time.Now()
+		for i := 0; i < 5; i++ {
+			c.Add("Video", fmt.Sprint(i), []byte(fmt.Sprintf("Tag %d", i)), time.Minute*time.Duration(5-i))
+		}
+		target := fmt.Sprintf("/bulk/Video?from=%d&to=%d&limit=2", n.Add(time.Second*150).Unix(), n.Add(time.Minute*10).Unix())
+		res := serveHttp(h, "GET", target, "")
+		if items := httpItems(res); res["result"] != float64(0) || len(items) != 2 || items[0] != "Tag 2" || items[1] != "Tag 1" {
+			t.Errorf("[%s] range get %v", engine, res)
+		}
+		if expires, _ := res["expires"].([]interface{}); len(expires) != 2 {
+			t.Errorf("[%s] range get %d expires", engine, len(expires))
+		}
+		if res := serveHttp(h, "GET", "/bulk/Video?from=soon", ""); res["result"] != float64(1) {
+			t.Errorf("[%s] invalid from is accepted", engine)
+		}
+		if res := serveHttp(h, "GET", "/bulk/Audio?limit=2", ""); res["result"] != float64(1) {
+			t.Errorf("[%s] range of missing bulk %v", engine, res)
+		}
+	}
+}
+
+func Test_HttpScanBulkItems(t *testing.T) {
+	c, _ := NewContainer("Http Scan", BTreeEngine)
+	other, _ := NewContainer("Http Scan Other", HashEngine)
+	h := NewEchoHttpServer(c)
+	h.Containers.Add(other)
+	for i := 0; i < 25; i++ {
+		other.Add("Video", fmt.Sprint(i), []byte(fmt.Sprintf("Tag %d", i)), time.Minute)
+	}
+	seen := map[string]bool{}
+	cursor := ScanStart
+	for pages := 0; pages < 10; pages++ {
+		res := serveHttp(h, "GET", "/c/Http%20Scan%20Other/bulk/Video?count=10&cursor="+cursor, "")
+		if res["result"] != float64(0) {
+			t.Fatalf("scan get %v", res)
+		}
+		for _, i := range httpItems(res) {
+			if seen[i] {
+				t.Errorf("%s is returned twice", i)
+			}
+			seen[i] = true
+		}
+		if cursor, _ = res["cursor"].(string); cursor == ScanStart {
+			break
+		}
+	}
+	if len(seen) != 25 || cursor != ScanStart {
+		t.Errorf("scan returns %d items, cursor %s", len(seen), cursor)
+	}
+	if res := serveHttp(h, "GET", "/bulk/Video?count=10", ""); res["result"] != float64(1) {
+		t.Errorf("scan of bulk in another container %v", res)
+	}
+	if res := serveHttp(h, "GET", "/c/Http%20Scan%20Other/bulk/Video?cursor=!", ""); res["result"] != float64(1) {
+		t.Errorf("invalid cursor is accepted")
+	}
+}
+
+func Test_HttpBulkEvents(t *testing.T) {
+	c, _ := NewContainer("Http Events", HashEngine)
+	h := NewEchoHttpServer(c)
+	if res := serveHttp(h, "GET", "/bulk/Video/events?events=nothing", ""); res["result"] != float64(1) {
+		t.Errorf("unknown event type is accepted")
+	}
+
+	rec := test.NewResponseRecorder()
+	w := &sseWriter{C: make(chan string, 16)}
+	rec.SetWriter(w)
+	done := make(chan struct{})
+	go func() {
+		h.Handler.ServeHTTP(test.NewRequest("GET", "/bulk/Video/events?events=add,remove", nil), rec)
+		close(done)
+	}()
+	for i := 0; atomic.LoadInt32(&c.subs.count) == 0; i++ {
+		if i == 100 {
+			t.Fatal("client does not subscribe")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	c.Add("Video", "a", []byte("Tag a"), time.Minute)
+	c.DeleteItem("Video", "a")
+	c.Remove("Video")
+	for _, want := range []string{"event: add\n", "event: remove\n"} {
+		select {
+		case e := <-w.C:
+			if !strings.HasPrefix(e, want) || !strings.HasSuffix(e, "\n\n") {
+				t.Errorf("get event %q, want %q", e, want)
+			}
+			if want == "event: add\n" && !strings.Contains(e, `"value":"Tag a"`) {
+				t.Errorf("add event has no value, get %q", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %q is not streamed", want)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type %s", ct)
+	}
+
+	//the stream ends on the first write after client leaves
+	atomic.StoreInt32(&w.closed, 1)
+	c.Add("Video", "b", []byte("Tag b"), time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream goes on after client leaves")
+	}
+	if n := atomic.LoadInt32(&c.subs.count); n != 0 {
+		t.Errorf("subscription is left, got %d", n)
+	}
+}
+
+func Test_HttpBatch(t *testing.T) {
+	c, _ := NewContainer("Http Batch", HashEngine)
+	h := NewEchoHttpServer(c)
+	c.Add("Audio", "a", []byte("Audio a"), time.Minute)
+	body := `[
+		{"op": "set", "bulk": "Video", "key": "a", "value": "Tag a", "expire": 60},
+		{"op": "set", "bulk": "Video", "key": "b", "value": "Tag b", "expire": 60},
+		{"op": "get", "bulk": "Video"},
+		{"op": "delete", "bulk": "Video", "key": "a"},
+		{"op": "delete", "bulk": "Video", "key": "missing"},
+		{"op": "remove", "bulk": "Audio"},
+		{"op": "get", "bulk": "Audio"},
+		{"op": "touch", "bulk": "Video"}
+	]`
+	res := serveHttp(h, "POST", "/batch", body)
+	results, _ := res["results"].([]interface{})
+	if res["result"] != float64(0) || len(results) != 8 {
+		t.Fatalf("batch get %v", res)
+	}
+	want := []float64{0, 0, 0, 0, 1, 0, 1, 1}
+	for n, r := range results {
+		if r.(map[string]interface{})["result"] != want[n] {
+			t.Errorf("op %d get %v, want result %v", n, r, want[n])
+		}
+	}
+	if items := httpItems(results[2].(map[string]interface{})); len(items) != 2 {
+		t.Errorf("get after set in batch returns %v", items)
+	}
+	if _, ok := c.GetItem("Video", "a"); ok || c.Has("Audio") {
+		t.Error("delete or remove of batch is not applied")
+	}
+	if i, ok := c.GetItem("Video", "b"); !ok || string(i.Data) != "Tag b" {
+		t.Error("set of batch is not applied")
+	}
+	if res := serveHttp(h, "POST", "/batch", "{"); res["result"] != float64(1) {
+		t.Error("invalid batch is accepted")
+	}
+}
+
+func Test_HttpBatchPermit(t *testing.T) {
+	c, _ := NewContainer("Http Batch Permit", HashEngine)
+	h := NewEchoHttpServer(c)
+	h.Auth, _ = ParseTokens(strings.NewReader(testTokens))
+	c.Add("image:a", "a", []byte("Image a"), time.Minute)
+	//denied sets between allowed ones keep results of the others in place
+	body := `[
+		{"op": "set", "bulk": "video:a", "key": "a", "value": "Video a", "expire": 60},
+		{"op": "set", "bulk": "image:a", "key": "b", "value": "Image b", "expire": 60},
+		{"op": "set", "bulk": "audio:a", "key": "a", "value": "Audio a", "expire": 60},
+		{"op": "set", "bulk": "image:b", "key": "a", "value": "Image a", "expire": 60},
+		{"op": "set", "bulk": "video:b", "key": "b", "value": "Video b", "expire": 60},
+		{"op": "get", "bulk": "image:a"},
+		{"op": "delete", "bulk": "image:a", "key": "a"},
+		{"op": "remove", "bulk": "image:a"}
+	]`
+	res := serveHttpAs(h, "feeder", "POST", "/batch", body)
+	results, _ := res["results"].([]interface{})
+	if res["result"] != float64(0) || len(results) != 8 {
+		t.Fatalf("batch get %v", res)
+	}
+	want := []float64{0, 1, 0, 1, 0, 0, 1, 1}
+	for n, r := range results {
+		r := r.(map[string]interface{})
+		if r["result"] != want[n] {
+			t.Errorf("op %d get %v, want result %v", n, r, want[n])
+		}
+		if denied := want[n] == 1; denied != (r["error"] == ErrDenied.Error()) {
+			t.Errorf("op %d get error %v", n, r["error"])
+		}
+	}
+	for _, bulk := range []string{"video:a", "audio:a", "video:b"} {
+		if !c.Has(bulk) {
+			t.Errorf("allowed set to %s is not applied", bulk)
+		}
+	}
+	if _, ok := c.GetItem("image:a", "b"); ok || c.Has("image:b") {
+		t.Error("denied set is applied")
+	}
+	if _, ok := c.GetItem("image:a", "a"); !ok {
+		t.Error("denied delete or remove is applied")
+	}
+	if res := serveHttpAs(h, "nobody", "POST", "/batch", body); res["result"] != float64(1) {
+		t.Error("unknown token runs batch")
+	}
+}
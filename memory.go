@@ -0,0 +1,141 @@
+package bulkCache
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	// MemoryLargestBulk evicts from the bulk holding most bytes
+	MemoryLargestBulk MemoryPolicy = iota
+	// MemoryLRUBulk evicts from the least recently used bulk
+	MemoryLRUBulk
+)
+
+type (
+	// MemoryPolicy chooses the bulk to evict from when container exceeds max memory
+	MemoryPolicy int
+)
+
+var (
+	memoryPolicies = map[MemoryPolicy]string{
+		MemoryLargestBulk: "largest",
+		MemoryLRUBulk:     "lru",
+	}
+)
+
+func ParseMemoryPolicy(s string) (MemoryPolicy, error) {
+	for p, name := range memoryPolicies {
+		if name == s {
+			return p, nil
+		}
+	}
+	return MemoryLargestBulk, fmt.Errorf("Unknown memory policy %s", s)
+}
+
+func (p MemoryPolicy) String() string {
+	if name, ok := memoryPolicies[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("MemoryPolicy(%d)", int(p))
+}
+
+// SetMaxMemory limits bytes of all bulks, 0 means unlimited.
+// Items are evicted in background after the add which exceeds the limit
+func (c *Container) SetMaxMemory(max int64, policy MemoryPolicy) {
+	c.Mut.Lock()
+	defer c.Mut.Unlock()
+	c.memoryPolicy = policy
+	if c.memorySignal == nil {
+		c.memorySignal = make(chan struct{}, 1)
+		go c.memoryKeeper()
+	}
+	atomic.StoreInt64(&c.maxMemory, max)
+	c.checkMemory()
+}
+
+func (c *Container) MaxMemory() int64 {
+	return atomic.LoadInt64(&c.maxMemory)
+}
+
+// checkMemory wakes up memory keeper without blocking
+func (c *Container) checkMemory() {
+	if c.MaxMemory() <= 0 {
+		return
+	}
+	select {
+	case c.memorySignal <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Container) memoryKeeper() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.memorySignal:
+			c.EvictMemory()
+		}
+	}
+}
+
+// EvictMemory evicts items across bulks until bytes of container fit max memory,
+// a bulk is evicted by its own eviction policy or oldest expire first if it rejects
+func (c *Container) EvictMemory() {
+	max := c.MaxMemory()
+	if max <= 0 {
+		return
+	}
+	c.Mut.RLock()
+	policy := c.memoryPolicy
+	c.Mut.RUnlock()
+
+	bulks := c.copyBulks()
+	sizes := map[string]int64{}
+	var used int64
+	for k, b := range bulks {
+		sizes[k] = int64(b.Bytes())
+		used += sizes[k]
+	}
+	evicted := 0
+	//adds during eviction signal the keeper again, so they are not measured here
+	for used > max && len(bulks) > 0 {
+		key := selectBulk(policy, bulks, sizes)
+		b := bulks[key]
+		bp := b.Config().EvictionPolicy
+		if bp == EvictReject {
+			bp = EvictOldestExpire
+		}
+		freed, ok := b.Evict(bp)
+		if !ok {
+			delete(bulks, key)
+			continue
+		}
+		sizes[key] -= int64(freed)
+		used -= int64(freed)
+		evicted++
+	}
+	if evicted > 0 {
+		c.Log.Warning(fmt.Sprintf("Evict %d items for max memory %d bytes", evicted, max))
+	}
+}
+
+func selectBulk(policy MemoryPolicy, bulks map[string]Bulk, sizes map[string]int64) string {
+	var key string
+	var best int64
+	for k, b := range bulks {
+		switch policy {
+		case MemoryLRUBulk:
+			a := atomic.LoadInt64(&b.Analytics().Access)
+			if key == "" || a < best {
+				key, best = k, a
+			}
+		default:
+			if key == "" || sizes[k] > best {
+				key, best = k, sizes[k]
+			}
+		}
+	}
+	return key
+}
@@ -0,0 +1,42 @@
+package bulkCache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_MaxMemory(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine} {
+		c, _ := NewContainer("Memory", engine)
+		value := make([]byte, 100-KeySize)
+		for i := 0; i < 10; i++ {
+			c.Add("Small", fmt.Sprint(i), value, time.Minute)
+		}
+		for i := 0; i < 30; i++ {
+			c.Add("Large", fmt.Sprint(i), value, time.Minute)
+		}
+		//every item takes 100 bytes with its padded sub key
+		c.SetMaxMemory(3000, MemoryLargestBulk)
+		c.EvictMemory()
+		small, _ := c.GetBulk("Small")
+		large, _ := c.GetBulk("Large")
+		if small.Len() != 10 {
+			t.Errorf("[%s] small bulk should not be evicted, got %d items", engine, small.Len())
+		}
+		if small.Bytes()+large.Bytes() > 3000 {
+			t.Errorf("[%s] container exceeds max memory", engine)
+		}
+		if c.Analytics.Evictions == 0 {
+			t.Errorf("[%s] evictions are not counted", engine)
+		}
+
+		c.SetMaxMemory(1500, MemoryLRUBulk)
+		c.GetItem("Large", "29")
+		c.Get("Large")
+		c.EvictMemory()
+		if small.Len() != 0 || small.Bytes()+large.Bytes() > 1500 {
+			t.Errorf("[%s] least recently used bulk should be evicted first", engine)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package bulkCache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics are written in Prometheus text exposition format version 0.0.4
+const (
+	MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+	metricsPrefix      = "bulkcache_"
+)
+
+var (
+	// DefaultLatencyBuckets are upper bounds in seconds from 10us to 1s,
+	// 4 buckets per power of 2
+	DefaultLatencyBuckets = HDRBuckets(time.Microsecond*10, time.Second, 4)
+)
+
+type (
+	// Histogram counts observed durations by bucket upper bound
+	Histogram struct {
+		Buckets []float64 //seconds, ascending
+		counts  []uint64
+		count   uint64
+		sum     int64 //nanoseconds
+	}
+
+	// LatencyVec holds a histogram per label
+	LatencyVec struct {
+		Mut     *sync.RWMutex
+		Buckets []float64
+		hists   map[string]*Histogram
+	}
+
+	// metricsWriter writes samples and keeps the first write error
+	metricsWriter struct {
+		w   *bufio.Writer
+		err error
+	}
+
+	labels []string //name value pairs
+)
+
+// HDRBuckets splits every power of 2 from min up to max into sub linear buckets,
+// so the error of a bucket is at most 1/sub of its bound like HDR histograms
+func HDRBuckets(min, max time.Duration, sub int) []float64 {
+	if sub <= 0 {
+		sub = 1
+	}
+	bs := []float64{}
+	for base := min; base < max; base *= 2 {
+		for i := 0; i < sub; i++ {
+			b := base + base*time.Duration(i)/time.Duration(sub)
+			if b >= max {
+				break
+			}
+			bs = append(bs, b.Seconds())
+		}
+	}
+	return append(bs, max.Seconds())
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{Buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(h.Buckets, s)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// Count returns observations
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum returns total of observations
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.sum))
+}
+
+// Cumulative returns observations less than or equal to each bucket
+func (h *Histogram) Cumulative() []uint64 {
+	cs := make([]uint64, len(h.counts))
+	var n uint64
+	for i := range h.counts {
+		n += atomic.LoadUint64(&h.counts[i])
+		cs[i] = n
+	}
+	return cs
+}
+
+func NewLatencyVec(buckets []float64) *LatencyVec {
+	return &LatencyVec{
+		Mut:     &sync.RWMutex{},
+		Buckets: buckets,
+		hists:   map[string]*Histogram{},
+	}
+}
+
+func (v *LatencyVec) Observe(label string, d time.Duration) {
+	v.Histogram(label).Observe(d)
+}
+
+// Histogram returns histogram of label, it is created on first use
+func (v *LatencyVec) Histogram(label string) *Histogram {
+	v.Mut.RLock()
+	h, ok := v.hists[label]
+	v.Mut.RUnlock()
+	if ok {
+		return h
+	}
+	v.Mut.Lock()
+	defer v.Mut.Unlock()
+	if h, ok = v.hists[label]; !ok {
+		h = NewHistogram(v.Buckets)
+		v.hists[label] = h
+	}
+	return h
+}
+
+// Labels returns labels having a histogram in order
+func (v *LatencyVec) Labels() []string {
+	v.Mut.RLock()
+	defer v.Mut.RUnlock()
+	ls := make([]string, 0, len(v.hists))
+	for l := range v.hists {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+func newMetricsWriter(w io.Writer) *metricsWriter {
+	return &metricsWriter{w: bufio.NewWriter(w)}
+}
+
+func (m *metricsWriter) printf(format string, a ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, a...)
+	}
+}
+
+// family writes HELP and TYPE of metric name
+func (m *metricsWriter) family(name, typ, help string) {
+	m.printf("# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+func (m *metricsWriter) sample(name string, ls labels, v float64) {
+	m.printf("%s%s%s %s\n", metricsPrefix, name, ls.String(), strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (m *metricsWriter) histogram(name string, ls labels, h *Histogram) {
+	le := func(bound string) labels {
+		return append(append(labels{}, ls...), "le", bound)
+	}
+	for i, n := range h.Cumulative() {
+		m.sample(name+"_bucket", le(strconv.FormatFloat(h.Buckets[i], 'g', -1, 64)), float64(n))
+	}
+	m.sample(name+"_bucket", le("+Inf"), float64(h.Count()))
+	m.sample(name+"_sum", ls, h.Sum().Seconds())
+	m.sample(name+"_count", ls, float64(h.Count()))
+}
+
+func (m *metricsWriter) Flush() error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.w.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (ls labels) String() string {
+	if len(ls) == 0 {
+		return ""
+	}
+	ps := make([]string, 0, len(ls)/2)
+	for i := 0; i+1 < len(ls); i += 2 {
+		ps = append(ps, ls[i]+`="`+labelEscaper.Replace(ls[i+1])+`"`)
+	}
+	return "{" + strings.Join(ps, ",") + "}"
+}
+
+var (
+	// analyticsMetrics are series of Analytics written for containers and bulks
+	analyticsMetrics = []struct {
+		name, typ, help string
+		value           func(*Analytics) int64
+	}{
+		{"memory_bytes", "gauge", "Bytes of alive values.", func(a *Analytics) int64 { return atomic.LoadInt64(&a.Memories) }},
+		{"key_bytes", "gauge", "Bytes of alive sub keys.", func(a *Analytics) int64 { return atomic.LoadInt64(&a.Keys) }},
+		{"adds_total", "counter", "Items added or overwritten.", func(a *Analytics) int64 { return atomic.LoadInt64(&a.Adds) }},
+		{"gets_total", "counter", "Get queries.", func(a *Analytics) int64 { return atomic.LoadInt64(&a.Queries) }},
+		{"hits_total", "counter", "Gets finding alive items.", func(a *Analytics) int64 { return atomic.LoadInt64(&a.Hits) }},
+		{"misses_total", "counter", "Gets finding nothing.", func(a *Analytics) int64 { return atomic.LoadInt64(&a.Misses) }},
+		{"evictions_total", "counter", "Items evicted by eviction or memory policy.", func(a *Analytics) int64 { return atomic.LoadInt64(&a.Evictions) }},
+		{"expirations_total", "counter", "Items removed after expire.", func(a *Analytics) int64 { return atomic.LoadInt64(&a.Expirations) }},
+	}
+)
+
+// WriteMetrics writes counters and gauges of container and each bulk
+func (c *Container) WriteMetrics(w io.Writer) error {
+	return writeContainerMetrics(w, []*Container{c})
+}
+
+// WriteMetrics writes metrics of every container, series are labeled by container name
+func (r *Registry) WriteMetrics(w io.Writer) error {
+	cs := []*Container{}
+	r.Each(func(c *Container) {
+		cs = append(cs, c)
+	})
+	return writeContainerMetrics(w, cs)
+}
+
+// writeContainerMetrics writes each family once with series of every container
+func writeContainerMetrics(w io.Writer, cs []*Container) error {
+	m := newMetricsWriter(w)
+	bulks := make([]map[string]Bulk, len(cs))
+	names := make([][]string, len(cs))
+	for i, c := range cs {
+		bulks[i] = c.copyBulks()
+		for name := range bulks[i] {
+			names[i] = append(names[i], name)
+		}
+		sort.Strings(names[i])
+	}
+
+	m.family("bulks", "gauge", "Bulks of container.")
+	for i, c := range cs {
+		m.sample("bulks", labels{"container", c.Name}, float64(len(bulks[i])))
+	}
+	m.family("max_memory_bytes", "gauge", "Max bytes of container, 0 means unlimited.")
+	for _, c := range cs {
+		m.sample("max_memory_bytes", labels{"container", c.Name}, float64(c.MaxMemory()))
+	}
+	for _, ct := range analyticsMetrics {
+		m.family("container_"+ct.name, ct.typ, ct.help)
+		for _, c := range cs {
+			m.sample("container_"+ct.name, labels{"container", c.Name}, float64(ct.value(c.Analytics)))
+		}
+	}
+
+	m.family("container_operation_duration_seconds", "histogram", "Latency of container operations.")
+	for _, c := range cs {
+		if c.Analytics.Latency == nil {
+			continue
+		}
+		for _, op := range c.Analytics.Latency.Labels() {
+			m.histogram("container_operation_duration_seconds", labels{"container", c.Name, "operation", op}, c.Analytics.Latency.Histogram(op))
+		}
+	}
+
+	bulkSamples := func(name string, value func(Bulk) float64) {
+		for i, c := range cs {
+			for _, bulk := range names[i] {
+				m.sample(name, labels{"container", c.Name, "bulk", bulk}, value(bulks[i][bulk]))
+			}
+		}
+	}
+	m.family("bulk_items", "gauge", "Items of bulk including expired ones not yet removed.")
+	bulkSamples("bulk_items", func(b Bulk) float64 { return float64(b.Len()) })
+	m.family("bulk_bytes", "gauge", "Bytes of sub keys and values of bulk.")
+	bulkSamples("bulk_bytes", func(b Bulk) float64 { return float64(b.Bytes()) })
+	for _, ct := range analyticsMetrics {
+		value := ct.value
+		m.family("bulk_"+ct.name, ct.typ, ct.help)
+		bulkSamples("bulk_"+ct.name, func(b Bulk) float64 { return float64(value(b.Analytics())) })
+	}
+	return m.Flush()
+}
+
+// WriteMetrics writes connections and command latency of Dage server
+func (d *Dage) WriteMetrics(w io.Writer) error {
+	m := newMetricsWriter(w)
+	d.Mut.Lock()
+	conns := len(d.Clients)
+	d.Mut.Unlock()
+	m.family("dage_connections", "gauge", "Connected Dage clients.")
+	m.sample("dage_connections", nil, float64(conns))
+	m.family("dage_command_duration_seconds", "histogram", "Latency of Dage commands.")
+	for _, cmd := range d.Latency.Labels() {
+		m.histogram("dage_command_duration_seconds", labels{"command", cmd}, d.Latency.Histogram(cmd))
+	}
+	return m.Flush()
+}
@@ -0,0 +1,90 @@
+package bulkCache
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Histogram(t *testing.T) {
+	h := NewHistogram([]float64{0.001, 0.01, 0.1})
+	h.Observe(time.Microsecond * 500)
+	h.Observe(time.Millisecond)
+	h.Observe(time.Millisecond * 50)
+	h.Observe(time.Second)
+	cs := h.Cumulative()
+	if cs[0] != 2 || cs[1] != 2 || cs[2] != 3 || h.Count() != 4 {
+		t.Errorf("cumulative %v count %d", cs, h.Count())
+	}
+	if h.Sum() != time.Microsecond*1051500 {
+		t.Errorf("sum %s", h.Sum())
+	}
+}
+
+func Test_HDRBuckets(t *testing.T) {
+	bs := HDRBuckets(time.Millisecond, time.Millisecond*10, 4)
+	want := []float64{0.001, 0.00125, 0.0015, 0.00175, 0.002, 0.0025, 0.003, 0.0035, 0.004, 0.005, 0.006, 0.007, 0.008, 0.01}
+	if len(bs) != len(want) {
+		t.Fatalf("buckets %v", bs)
+	}
+	for i := range bs {
+		if bs[i] != want[i] {
+			t.Errorf("bucket %d is %v, want %v", i, bs[i], want[i])
+		}
+	}
+}
+
+func Test_Metrics(t *testing.T) {
+	c, _ := NewContainer("Metrics", HashEngine)
+	c.Add("Video \"1\"", "a", []byte("Tag a"), time.Minute)
+	c.GetItem("Video \"1\"", "a")
+	c.GetItem("Video \"1\"", "b")
+	c.GetItem("Not Found", "a")
+	b := &bytes.Buffer{}
+	if err := c.WriteMetrics(b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, l := range []string{
+		"# TYPE bulkcache_container_hits_total counter\n",
+		`bulkcache_bulks{container="Metrics"} 1` + "\n",
+		`bulkcache_container_adds_total{container="Metrics"} 1` + "\n",
+		`bulkcache_container_hits_total{container="Metrics"} 1` + "\n",
+		`bulkcache_container_misses_total{container="Metrics"} 2` + "\n",
+		`bulkcache_bulk_items{container="Metrics",bulk="Video \"1\""} 1` + "\n",
+		`bulkcache_bulk_misses_total{container="Metrics",bulk="Video \"1\""} 1` + "\n",
+		`bulkcache_container_operation_duration_seconds_count{container="Metrics",operation="add"} 1` + "\n",
+		`bulkcache_container_operation_duration_seconds_count{container="Metrics",operation="get_item"} 3` + "\n",
+	} {
+		if !strings.Contains(out, l) {
+			t.Errorf("metrics miss %q", l)
+		}
+	}
+
+	d := newTestDage()
+	defer d.Listener.Close()
+	cli := NewDageClient()
+	cli.Addr = d.Listener.Addr().String()
+	cli.MaxConns = 1
+	defer cli.Close()
+	if err := cli.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := d.WriteMetrics(b); err != nil {
+		t.Fatal(err)
+	}
+	out = b.String()
+	for _, l := range []string{
+		"bulkcache_dage_connections 1\n",
+		"# TYPE bulkcache_dage_command_duration_seconds histogram\n",
+		`bulkcache_dage_command_duration_seconds_bucket{command="PING",le="+Inf"} 1` + "\n",
+		`bulkcache_dage_command_duration_seconds_count{command="PING"} 1` + "\n",
+	} {
+		if !strings.Contains(out, l) {
+			t.Errorf("dage metrics miss %q in\n%s", l, out)
+		}
+	}
+}
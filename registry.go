@@ -0,0 +1,119 @@
+package bulkCache
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrContainerNotFound = errors.New("Container is not found")
+	ErrContainerExists   = errors.New("Container exists")
+
+	defaultsOnce = &sync.Once{}
+	defaults     *Registry
+	//package globals are ready on load, a container starts nothing until a bulk is added
+	_ = Defaults()
+)
+
+type (
+	// Registry holds named containers served by one bulkd,
+	// the default container serves clients selecting none
+	Registry struct {
+		Mut        *sync.RWMutex
+		containers map[string]*Container
+		def        *Container
+	}
+)
+
+// Defaults sets up Default, DageApi, HttpApi and RespApi once, those already set
+// are kept, and returns the registry of Default the servers share. DageApi and
+// RespApi log slow commands to DefaultSlowLog
+func Defaults() *Registry {
+	defaultsOnce.Do(func() {
+		if Default == nil {
+			Default, _ = NewContainer("", "")
+		}
+		defaults = NewRegistry(Default)
+		if DageApi == nil {
+			DageApi = NewDage(Default)
+			DageApi.Containers = defaults
+			DageApi.SlowLog = DefaultSlowLog
+		}
+		if HttpApi == nil {
+			HttpApi = NewEchoHttpServer(Default)
+			HttpApi.Containers = defaults
+			HttpApi.Dage = DageApi
+		}
+		if RespApi == nil {
+			RespApi = NewRespServer(Default)
+			RespApi.SlowLog = DefaultSlowLog
+		}
+	})
+	return defaults
+}
+
+// NewRegistry creates a registry whose default container is def
+func NewRegistry(def *Container) *Registry {
+	r := &Registry{
+		Mut:        &sync.RWMutex{},
+		containers: map[string]*Container{},
+	}
+	if def != nil {
+		r.containers[def.Name] = def
+		r.def = def
+	}
+	return r
+}
+
+// Add registers c by its name, the first container added is the default one
+func (r *Registry) Add(c *Container) error {
+	r.Mut.Lock()
+	defer r.Mut.Unlock()
+	if _, ok := r.containers[c.Name]; ok {
+		return ErrContainerExists
+	}
+	r.containers[c.Name] = c
+	if r.def == nil {
+		r.def = c
+	}
+	return nil
+}
+
+// Get returns container of name, an empty name returns the default container
+func (r *Registry) Get(name string) (*Container, bool) {
+	r.Mut.RLock()
+	defer r.Mut.RUnlock()
+	if name == "" {
+		return r.def, r.def != nil
+	}
+	c, ok := r.containers[name]
+	return c, ok
+}
+
+func (r *Registry) Default() *Container {
+	r.Mut.RLock()
+	defer r.Mut.RUnlock()
+	return r.def
+}
+
+// Names returns sorted names of containers
+func (r *Registry) Names() []string {
+	r.Mut.RLock()
+	defer r.Mut.RUnlock()
+	names := make([]string, 0, len(r.containers))
+	for name := range r.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Each calls handler with containers in order of names
+func (r *Registry) Each(handler func(*Container)) {
+	for _, name := range r.Names() {
+		if c, ok := r.Get(name); ok {
+			handler(c)
+		}
+	}
+}
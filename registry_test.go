@@ -0,0 +1,104 @@
+package bulkCache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Registry(t *testing.T) {
+	a, _ := NewContainer("Registry A", HashEngine)
+	b, _ := NewContainer("Registry B", BTreeEngine)
+	r := NewRegistry(nil)
+	if _, ok := r.Get(""); ok {
+		t.Errorf("empty registry has a default container")
+	}
+	r.Add(b)
+	if err := r.Add(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(a); err != ErrContainerExists {
+		t.Errorf("container is added twice, got %v", err)
+	}
+	if c, _ := r.Get(""); c != b {
+		t.Errorf("first container added is not the default one")
+	}
+	if c, ok := r.Get("Registry A"); !ok || c != a {
+		t.Errorf("container is not found by name")
+	}
+	if _, ok := r.Get("Registry C"); ok {
+		t.Errorf("unknown container is found")
+	}
+	if names := r.Names(); len(names) != 2 || names[0] != "Registry A" || names[1] != "Registry B" {
+		t.Errorf("names are not sorted, got %v", names)
+	}
+}
+
+func Test_DageSelect(t *testing.T) {
+	def, _ := NewContainer("Select Default", HashEngine)
+	other, _ := NewContainer("Select Other", BTreeEngine)
+	d := newTestDage()
+	defer d.Listener.Close()
+	d.Containers = NewRegistry(def)
+	d.Containers.Add(other)
+	ctx := context.Background()
+	for _, binary := range []bool{true, false} {
+		bulk := fmt.Sprintf("Select %v", binary)
+		c := NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		c.Container = other.Name
+		if err := c.Set(ctx, bulk, "k", []byte("selected"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if _, ok := other.Get(bulk); !ok {
+			t.Errorf("[binary %v] item is not set on selected container", binary)
+		}
+		if _, ok := def.Get(bulk); ok {
+			t.Errorf("[binary %v] item is set on default container", binary)
+		}
+
+		c = NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		if vs, _ := c.Get(ctx, bulk); len(vs) != 0 {
+			t.Errorf("[binary %v] default container holds items of selected one", binary)
+		}
+		if binary {
+			if f, err := c.Call(ctx, OpSelect, []byte("Select Missing")); err != nil || f.Code != StatusNotFound {
+				t.Errorf("[binary %v] unknown container is selected", binary)
+			}
+		} else if res, err := c.Do(ctx, Select, "Select Missing"); err != nil || res != Failure {
+			t.Errorf("[binary %v] unknown container is selected, got %s", binary, res)
+		}
+		c.Close()
+
+		c = NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		c.Container = "Select Missing"
+		if err := c.Ping(ctx); err != ErrContainerNotFound {
+			t.Errorf("[binary %v] dial selecting unknown container, got %v", binary, err)
+		}
+		c.Close()
+	}
+}
+
+func Test_Defaults(t *testing.T) {
+	r := Defaults()
+	if Defaults() != r || r.Default() != Default {
+		t.Fatal("defaults are set up twice")
+	}
+	if DageApi.Containers != r || HttpApi.Containers != r || RespApi.DB != Default || HttpApi.Dage != DageApi {
+		t.Error("package servers do not share the registry of Default")
+	}
+	if err := Default.Add("Defaults", "a", []byte("value"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Default.GetItem("Defaults", "a"); !ok {
+		t.Error("item is not added to Default")
+	}
+	Default.Remove("Defaults")
+}
@@ -0,0 +1,511 @@
+package bulkCache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Redis commands mapped onto Container, a redis key is a bulk and a hash field is a sub key
+//
+//	HSET bulk sub value [sub value ...]  adds items expiring by TTL of bulk or RespExpire
+//	EXPIRE bulk seconds                  moves expire of alive items, later HSET keeps the TTL
+//	HGET bulk sub, HGETALL bulk, HDEL bulk sub [sub ...]
+//	DEL bulk [bulk ...], DBSIZE, SCAN cursor [MATCH pattern] [COUNT count]
+//	PING [message], INFO, QUIT, AUTH [username] token
+
+var (
+	RespApi *RespServer //RESP server of Default, set up by Defaults
+
+	// RespExpire is the expire of items added by HSET to a bulk without EXPIRE
+	RespExpire = time.Hour * 24
+
+	ErrRespProtocol = errors.New("Protocol error")
+)
+
+const (
+	maxRespLen = 1 << 20 //bounds array size and bulk length read before allocating
+)
+
+type (
+	RespServer struct {
+		Mut      *sync.Mutex
+		Listener net.Listener
+		Log      *log.Entry
+		Auth     *Auth                //tokens required by AUTH, nil lets everyone in
+		TLS      *tls.Config          //listener is wrapped by TLS if not nil
+		SlowLog  *SlowLog             //slow commands, nil logs nothing
+		DB       *Container           //container served to clients
+		ttls     map[string]time.Time //bulk => deadline set by EXPIRE
+	}
+
+	respWriter struct {
+		*bufio.Writer
+	}
+)
+
+// NewRespServer creates a RESP server of c
+func NewRespServer(c *Container) *RespServer {
+	return &RespServer{
+		Mut:     &sync.Mutex{},
+		DB:      c,
+		SlowLog: NewSlowLog(DefaultSlowThreshold, DefaultSlowLogSize),
+		ttls:    map[string]time.Time{},
+		Log: log.WithFields(log.Fields{
+			"Api": "Redis protocol",
+		}),
+	}
+}
+
+func (s *RespServer) Listen(port string) {
+	s.Log.Info(fmt.Sprintf("Start RESP server on %s", port))
+	listener, err := listen(port, s.TLS)
+	if err != nil {
+		s.Log.Error(fmt.Sprintf("Listen RESP server on %s error[%s]", port, err.Error()))
+		os.Exit(1)
+	}
+	s.Listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					continue
+				}
+				s.Log.Error(fmt.Sprintf("Accept RESP client error[%s]", err.Error()))
+				return
+			}
+			go s.Handle(conn)
+		}
+	}()
+}
+
+// Handle serves commands of conn, replies of pipelined commands are flushed together
+func (s *RespServer) Handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := &respWriter{bufio.NewWriter(conn)}
+	var token *Token
+	for {
+		args, err := readRespCommand(r)
+		if err != nil {
+			if err == ErrRespProtocol {
+				w.Error("ERR " + err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cmd := strings.ToUpper(args[0])
+		quit := cmd == Quit
+		if cmd == Login {
+			token = s.authenticate(args[1:], token, w)
+		} else if err := s.permit(token, cmd, args[1:]); err == ErrUnauthorized {
+			w.Error("NOAUTH Authentication required.")
+		} else if err != nil {
+			w.Error("NOPERM " + err.Error())
+		} else {
+			start := time.Now()
+			s.Command(args, w)
+			s.observe(conn, args, start)
+		}
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// observe logs command of args if slow
+func (s *RespServer) observe(conn net.Conn, args []string, start time.Time) {
+	elapsed := time.Since(start)
+	if s.SlowLog == nil || !s.SlowLog.Slow(elapsed) {
+		return
+	}
+	e := SlowEntry{Time: start, Command: strings.ToUpper(args[0]), Duration: elapsed, Client: conn.RemoteAddr().String()}
+	if len(args) > 1 {
+		e.Bulk = args[1]
+	}
+	for _, a := range args[1:] {
+		e.Size += len(a)
+	}
+	s.SlowLog.Record(e)
+}
+
+func (s *RespServer) Command(args []string, w *respWriter) {
+	cmd := strings.ToUpper(args[0])
+	params := args[1:]
+	switch cmd {
+	case Ping:
+		if len(params) > 0 {
+			w.Bulk([]byte(params[0]))
+		} else {
+			w.Simple(Pong)
+		}
+	case Quit:
+		w.Simple("OK")
+	case "HSET", "HMSET":
+		s.hset(cmd, params, w)
+	case "HGET":
+		s.hget(params, w)
+	case "HGETALL":
+		s.hgetall(params, w)
+	case "HDEL":
+		s.hdel(params, w)
+	case "DEL":
+		s.del(params, w)
+	case "EXPIRE":
+		s.expire(params, w)
+	case "DBSIZE":
+		w.Integer(int64(len(s.DB.copyBulks())))
+	case "SCAN":
+		s.scan(params, w)
+	case "INFO":
+		s.info(w)
+	default:
+		w.Error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+// authenticate replies AUTH and returns token of connection
+func (s *RespServer) authenticate(params []string, token *Token, w *respWriter) *Token {
+	if len(params) < 1 || len(params) > 2 {
+		w.Error("ERR wrong number of arguments for 'auth' command")
+		return token
+	}
+	if s.Auth == nil {
+		w.Error("ERR AUTH called without any password configured")
+		return token
+	}
+	t, err := s.Auth.Authenticate(params[len(params)-1])
+	if err != nil {
+		w.Error("WRONGPASS invalid username-password pair")
+		return token
+	}
+	w.Simple("OK")
+	return t
+}
+
+// permit checks token of connection for command cmd
+func (s *RespServer) permit(t *Token, cmd string, params []string) error {
+	switch cmd {
+	case Ping, Quit:
+		return nil
+	case "HSET", "HMSET", "HDEL", "EXPIRE":
+		if len(params) == 0 {
+			return nil
+		}
+		return s.Auth.Permit(t, true, params[0])
+	case "DEL":
+		return s.Auth.Permit(t, true, params...)
+	}
+	return s.Auth.Permit(t, false)
+}
+
+// ttl returns expire of items added to bulk
+func (s *RespServer) ttl(bulk string) time.Duration {
+	s.Mut.Lock()
+	defer s.Mut.Unlock()
+	if deadline, ok := s.ttls[bulk]; ok {
+		if d := time.Until(deadline); d > 0 && s.DB.Has(bulk) {
+			return d
+		}
+		delete(s.ttls, bulk)
+	}
+	return RespExpire
+}
+
+//params bulk sub value [sub value ...]
+func (s *RespServer) hset(cmd string, params []string, w *respWriter) {
+	if len(params) < 3 || len(params)%2 != 1 {
+		w.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+		return
+	}
+	bulk := params[0]
+	expire := s.ttl(bulk)
+	added := 0
+	for i := 1; i < len(params); i += 2 {
+		if _, ok := s.DB.GetItem(bulk, params[i]); !ok {
+			added++
+		}
+		if err := s.DB.Add(bulk, params[i], []byte(params[i+1]), expire); err != nil {
+			w.Error("ERR " + err.Error())
+			return
+		}
+	}
+	if cmd == "HMSET" {
+		w.Simple("OK")
+		return
+	}
+	w.Integer(int64(added))
+}
+
+//params bulk sub
+func (s *RespServer) hget(params []string, w *respWriter) {
+	if len(params) != 2 {
+		w.Error("ERR wrong number of arguments for 'hget' command")
+		return
+	}
+	i, ok := s.DB.GetItem(params[0], params[1])
+	if !ok {
+		w.Bulk(nil)
+		return
+	}
+	w.Bulk(i.Data)
+}
+
+//params bulk, reply is sub value pairs ordered by sub
+func (s *RespServer) hgetall(params []string, w *respWriter) {
+	if len(params) != 1 {
+		w.Error("ERR wrong number of arguments for 'hgetall' command")
+		return
+	}
+	cached, _ := s.DB.Get(params[0])
+	subs := make([]string, 0, len(cached))
+	for sub := range cached {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	w.Array(len(subs) * 2)
+	for _, sub := range subs {
+		w.Bulk([]byte(TrimKey(sub)))
+		w.Bulk(cached[sub].Data)
+	}
+}
+
+//params bulk sub [sub ...]
+func (s *RespServer) hdel(params []string, w *respWriter) {
+	if len(params) < 2 {
+		w.Error("ERR wrong number of arguments for 'hdel' command")
+		return
+	}
+	n := 0
+	for _, sub := range params[1:] {
+		if s.DB.DeleteItem(params[0], sub) {
+			n++
+		}
+	}
+	w.Integer(int64(n))
+}
+
+//params bulk [bulk ...]
+func (s *RespServer) del(params []string, w *respWriter) {
+	if len(params) < 1 {
+		w.Error("ERR wrong number of arguments for 'del' command")
+		return
+	}
+	n := 0
+	for _, bulk := range params {
+		if s.DB.Has(bulk) {
+			s.DB.Remove(bulk)
+			n++
+		}
+		s.Mut.Lock()
+		delete(s.ttls, bulk)
+		s.Mut.Unlock()
+	}
+	w.Integer(int64(n))
+}
+
+//params bulk seconds, reply 1 if bulk exists
+func (s *RespServer) expire(params []string, w *respWriter) {
+	if len(params) != 2 {
+		w.Error("ERR wrong number of arguments for 'expire' command")
+		return
+	}
+	seconds, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil {
+		w.Error("ERR value is not an integer or out of range")
+		return
+	}
+	bulk := params[0]
+	cached, ok := s.DB.Get(bulk)
+	if !ok {
+		w.Integer(0)
+		return
+	}
+	if seconds <= 0 {
+		s.DB.Remove(bulk)
+		s.Mut.Lock()
+		delete(s.ttls, bulk)
+		s.Mut.Unlock()
+		w.Integer(1)
+		return
+	}
+	expire := time.Duration(seconds) * time.Second
+	s.Mut.Lock()
+	s.ttls[bulk] = time.Now().Add(expire)
+	s.Mut.Unlock()
+	for sub, i := range cached {
+		s.DB.UpdateItem(bulk, sub, i.Data, expire)
+	}
+	w.Integer(1)
+}
+
+//params cursor [MATCH pattern] [COUNT count], cursor is the offset in sorted bulk names
+func (s *RespServer) scan(params []string, w *respWriter) {
+	if len(params) < 1 || len(params)%2 != 1 {
+		w.Error("ERR syntax error")
+		return
+	}
+	cursor, err := strconv.Atoi(params[0])
+	if err != nil || cursor < 0 {
+		w.Error("ERR invalid cursor")
+		return
+	}
+	pattern, count := "*", DefaultScanCount
+	for i := 1; i < len(params); i += 2 {
+		switch strings.ToUpper(params[i]) {
+		case "MATCH":
+			pattern = params[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(params[i+1]); err != nil || count <= 0 {
+				w.Error("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.Error("ERR syntax error")
+			return
+		}
+	}
+	names := []string{}
+	for name := range s.DB.copyBulks() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	keys := []string{}
+	next := cursor
+	for ; next < len(names) && next < cursor+count; next++ {
+		if ok, _ := path.Match(pattern, names[next]); ok {
+			keys = append(keys, names[next])
+		}
+	}
+	if next >= len(names) {
+		next = 0
+	}
+	w.Array(2)
+	w.Bulk([]byte(strconv.Itoa(next)))
+	w.Array(len(keys))
+	for _, k := range keys {
+		w.Bulk([]byte(k))
+	}
+}
+
+func (s *RespServer) info(w *respWriter) {
+	bulks := s.DB.copyBulks()
+	items := 0
+	for _, b := range bulks {
+		items += b.Len()
+	}
+	lines := []string{
+		"# Server",
+		"name:" + s.DB.Name,
+		"engine:" + s.DB.Engine,
+		"",
+		"# Memory",
+		fmt.Sprintf("used_memory:%d", s.DB.Analytics.Bytes()),
+		fmt.Sprintf("maxmemory:%d", s.DB.MaxMemory()),
+		"",
+		"# Stats",
+		fmt.Sprintf("total_queries:%d", s.DB.Analytics.Queries),
+		fmt.Sprintf("expired_keys:%d", s.DB.Analytics.Expirations),
+		fmt.Sprintf("evicted_keys:%d", s.DB.Analytics.Evictions),
+		fmt.Sprintf("keyspace_hits:%d", s.DB.Analytics.Hits),
+		fmt.Sprintf("keyspace_misses:%d", s.DB.Analytics.Misses),
+		"",
+		"# Keyspace",
+		fmt.Sprintf("db0:keys=%d,items=%d", len(bulks), items),
+	}
+	w.Bulk([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+}
+
+// readRespCommand reads an array of bulk strings or an inline command
+func readRespCommand(r *bufio.Reader) ([]string, error) {
+	l, err := readRespLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(l) == 0 || l[0] != '*' {
+		return strings.Fields(l), nil
+	}
+	n, err := strconv.Atoi(l[1:])
+	if err != nil || n < 0 || n > maxRespLen {
+		return nil, ErrRespProtocol
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := readRespLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(l) == 0 || l[0] != '$' {
+			return nil, ErrRespProtocol
+		}
+		size, err := strconv.Atoi(l[1:])
+		if err != nil || size < 0 || size > maxRespLen {
+			return nil, ErrRespProtocol
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if string(b[size:]) != "\r\n" {
+			return nil, ErrRespProtocol
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+func readRespLine(r *bufio.Reader) (string, error) {
+	l, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(l, "\r\n"), nil
+}
+
+func (w *respWriter) Simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *respWriter) Error(s string) {
+	w.WriteString("-" + s + "\r\n")
+}
+
+func (w *respWriter) Integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// Bulk writes a bulk string, nil is the null bulk string
+func (w *respWriter) Bulk(b []byte) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) Array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
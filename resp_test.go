@@ -0,0 +1,130 @@
+package bulkCache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func respCommand(args ...string) string {
+	s := fmt.Sprintf("*%d\r\n", len(args))
+	for _, a := range args {
+		s += fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+	}
+	return s
+}
+
+// readRespReply reads a reply and flattens it to one line
+func readRespReply(r *bufio.Reader) (string, error) {
+	l, err := readRespLine(r)
+	if err != nil || len(l) == 0 {
+		return l, err
+	}
+	switch l[0] {
+	case '$':
+		n := 0
+		fmt.Sscanf(l[1:], "%d", &n)
+		if n < 0 {
+			return "nil", nil
+		}
+		b := make([]byte, n+2)
+		_, err := io.ReadFull(r, b)
+		return string(b[:n]), err
+	case '*':
+		n := 0
+		fmt.Sscanf(l[1:], "%d", &n)
+		items := []string{}
+		for i := 0; i < n; i++ {
+			item, err := readRespReply(r)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		return "[" + strings.Join(items, " ") + "]", nil
+	}
+	return l, nil
+}
+
+func Test_RespServer(t *testing.T) {
+	db, _ := NewContainer("", "")
+	s := NewRespServer(db)
+	s.Listen("127.0.0.1:0")
+	defer s.Listener.Close()
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	r := bufio.NewReader(conn)
+
+	cases := []struct {
+		cmd  []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG"},
+		{[]string{"HSET", "Resp Video", "a", "Tag a", "b", "Tag\r\nb"}, ":2"},
+		{[]string{"HSET", "Resp Video", "a", "Tag A"}, ":0"},
+		{[]string{"HGET", "Resp Video", "a"}, "Tag A"},
+		{[]string{"HGET", "Resp Video", "c"}, "nil"},
+		{[]string{"HGETALL", "Resp Video"}, "[a Tag A b Tag\r\nb]"},
+		{[]string{"EXPIRE", "Resp Video", "100"}, ":1"},
+		{[]string{"EXPIRE", "Not Found", "100"}, ":0"},
+		{[]string{"HDEL", "Resp Video", "b", "c"}, ":1"},
+		{[]string{"SCAN", "0", "MATCH", "Resp *", "COUNT", "1000"}, "[0 [Resp Video]]"},
+		{[]string{"HSET", "Resp Video"}, "-ERR wrong number of arguments for 'hset' command"},
+		{[]string{"UNKNOWN"}, "-ERR unknown command 'UNKNOWN'"},
+		{[]string{"DEL", "Resp Video", "Not Found"}, ":1"},
+		{[]string{"HGETALL", "Resp Video"}, "[]"},
+	}
+	for _, c := range cases {
+		conn.Write([]byte(respCommand(c.cmd...)))
+		got, err := readRespReply(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%v get %q, want %q", c.cmd, got, c.want)
+		}
+	}
+
+	//item added after EXPIRE keeps the ttl of bulk
+	conn.Write([]byte(respCommand("HSET", "Resp Video", "a", "1") + respCommand("EXPIRE", "Resp Video", "100") + respCommand("HSET", "Resp Video", "b", "2")))
+	for i := 0; i < 3; i++ {
+		readRespReply(r)
+	}
+	if i, ok := db.GetItem("Resp Video", "b"); !ok || i.Expire.After(time.Now().Add(time.Second*100)) {
+		t.Errorf("item added after expire get %v", i)
+	}
+
+	//inline command
+	conn.Write([]byte("DBSIZE\r\n"))
+	if got, _ := readRespReply(r); !strings.HasPrefix(got, ":") {
+		t.Errorf("inline dbsize get %q", got)
+	}
+	conn.Write([]byte(respCommand("INFO")))
+	if got, _ := readRespLine(r); !strings.HasPrefix(got, "$") {
+		t.Errorf("info get %q", got)
+	}
+}
+
+func Test_RespProtocolBounds(t *testing.T) {
+	for _, cmd := range []string{
+		"*-1\r\n",
+		"*2000000\r\n",
+		"*1\r\n$-5\r\nHGET\r\n",
+		"*1\r\n$2000000\r\nHGET\r\n",
+	} {
+		if _, err := readRespCommand(bufio.NewReader(strings.NewReader(cmd))); err != ErrRespProtocol {
+			t.Errorf("%q should be a protocol error, got %v", cmd, err)
+		}
+	}
+	if args, err := readRespCommand(bufio.NewReader(strings.NewReader(respCommand("HGET", "Video", "a")))); err != nil || len(args) != 3 {
+		t.Errorf("valid command is rejected, got %v %v", args, err)
+	}
+}
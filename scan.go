@@ -0,0 +1,148 @@
+package bulkCache
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"sort"
+	"time"
+
+	"github.com/emirpasic/gods/trees/btree"
+)
+
+const (
+	// ScanStart is the cursor to start a scan and the cursor returned when it ends
+	ScanStart = "0"
+
+	DefaultScanCount = 10
+)
+
+type (
+	// ScanBulk is a bulk which can be read page by page,
+	// cursor is an engine defined position, "" starts the scan and ends it
+	ScanBulk interface {
+		Bulk
+		Scan(cursor string, count int) ([]KeyItem, string)
+	}
+
+	// keyHeap is a max heap keeping the smallest keys
+	keyHeap []string
+)
+
+func (h keyHeap) Len() int            { return len(h) }
+func (h keyHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h keyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// scanCached returns the next count alive items of cached after cursor in key order,
+// only count keys are kept while walking cached
+func scanCached(cached Cached, cursor string, count int) ([]KeyItem, string) {
+	n := time.Now()
+	h := &keyHeap{}
+	for k, v := range cached {
+		if k <= cursor || n.After(v.Expire) {
+			continue
+		}
+		if h.Len() < count {
+			heap.Push(h, k)
+		} else if k < (*h)[0] {
+			(*h)[0] = k
+			heap.Fix(h, 0)
+		}
+	}
+	keys := []string(*h)
+	sort.Strings(keys)
+	its := make([]KeyItem, 0, len(keys))
+	for _, k := range keys {
+		its = append(its, KeyItem{Key: k, Item: cached[k]})
+	}
+	if len(keys) < count {
+		return its, ""
+	}
+	return its, keys[len(keys)-1]
+}
+
+// scanTree returns the next count alive items of t after cursor,
+// t maps sub keys to items so the walk starts at cursor and stops after count items
+func scanTree(t *btree.Tree, cursor string, count int) ([]KeyItem, string) {
+	n := time.Now()
+	its := make([]KeyItem, 0, count)
+	next := ""
+	seekTree(t, cursor, func(k string, v interface{}) bool {
+		i, _ := v.(*Item)
+		if k == cursor || i == nil || !n.Before(i.Expire) {
+			return true
+		}
+		its = append(its, KeyItem{Key: k, Item: i})
+		if len(its) < count {
+			return true
+		}
+		next = k
+		return false
+	})
+	return its, next
+}
+
+// seekTree calls fn with entries of t from the first key not less than from in key order,
+// until fn returns false
+func seekTree(t *btree.Tree, from string, fn func(key string, v interface{}) bool) {
+	seekNode(t.Root, from, fn)
+}
+
+func seekNode(n *btree.Node, from string, fn func(key string, v interface{}) bool) bool {
+	if n == nil {
+		return true
+	}
+	i := sort.Search(len(n.Entries), func(i int) bool {
+		return n.Entries[i].Key.(string) >= from
+	})
+	//entries and children left of i only hold keys before from
+	for ; i <= len(n.Entries); i++ {
+		if i < len(n.Children) && !seekNode(n.Children[i], from, fn) {
+			return false
+		}
+		if i < len(n.Entries) && !fn(n.Entries[i].Key.(string), n.Entries[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Scan returns at most count alive items of bulk after cursor and the cursor of next page,
+// scan starts and ends with ScanStart. Items alive during the whole scan are returned once.
+func (c *Container) Scan(key, cursor string, count int) ([]KeyItem, string, error) {
+	b, ok := c.GetBulk(key)
+	if !ok {
+		return nil, ScanStart, ErrBulkNotFound
+	}
+	c.Analytics.Get()
+	b.Analytics().Get()
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	raw := ""
+	if cursor != ScanStart && cursor != "" {
+		d, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, ScanStart, err
+		}
+		raw = string(d)
+	}
+
+	var its []KeyItem
+	var next string
+	if sb, ok := b.(ScanBulk); ok {
+		its, next = sb.Scan(raw, count)
+	} else {
+		its, next = scanCached(b.GetAlive(), raw, count)
+	}
+	if next == "" {
+		return its, ScanStart, nil
+	}
+	return its, base64.RawURLEncoding.EncodeToString([]byte(next)), nil
+}
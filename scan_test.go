@@ -0,0 +1,73 @@
+package bulkCache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Scan(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine, ShardedEngine} {
+		c, _ := NewContainer("Scan", engine)
+		n := 95
+		for i := 0; i < n; i++ {
+			c.Add("Video", fmt.Sprint(i), []byte(fmt.Sprintf("Tag %d", i)), time.Minute)
+		}
+		seen := map[string]bool{}
+		cursor := ScanStart
+		pages := 0
+		for {
+			its, next, err := c.Scan("Video", cursor, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(its) > 10 {
+				t.Errorf("[%s] page has %d items", engine, len(its))
+			}
+			for _, i := range its {
+				if seen[string(i.Data)] {
+					t.Errorf("[%s] %s is returned twice", engine, i.Data)
+				}
+				seen[string(i.Data)] = true
+			}
+			pages++
+			//writes during scan do not break the cursor
+			if pages == 3 {
+				c.Add("Video", "new", []byte("New"), time.Minute)
+				c.DeleteItem("Video", "94")
+				//items returned already are not returned again with a later expire
+				for _, i := range its {
+					c.Add("Video", i.Key, i.Data, time.Hour)
+				}
+			}
+			if next == ScanStart {
+				break
+			}
+			cursor = next
+		}
+		delete(seen, "New")
+		if len(seen) < n-1 {
+			t.Errorf("[%s] scan returns %d items, want at least %d", engine, len(seen), n-1)
+		}
+		if _, _, err := c.Scan("Video", "!", 10); err == nil {
+			t.Errorf("[%s] invalid cursor should fail", engine)
+		}
+	}
+}
+
+func Test_ScanTreeSeek(t *testing.T) {
+	b := newHashBulk(nil, Cached{})
+	for i := 0; i < 50; i += 2 {
+		b.AddAt(fmt.Sprintf("%02d", i), []byte("Tag"), time.Now().Add(time.Minute))
+	}
+	b.AddAt("21", []byte("Expired"), time.Now().Add(-time.Minute))
+	//cursor 19 is not a key, the page starts at the next key and skips expired ones
+	its, next := b.Scan("19", 3)
+	if len(its) != 3 || its[0].Key != "20" || its[1].Key != "22" || its[2].Key != "24" || next != "24" {
+		t.Errorf("scan from 19 returns %v, %s", its, next)
+	}
+	its, next = b.Scan("46", 3)
+	if len(its) != 1 || its[0].Key != "48" || next != "" {
+		t.Errorf("last page returns %v, %s", its, next)
+	}
+}
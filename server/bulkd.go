@@ -3,24 +3,208 @@ package main
 import (
 	cache "bulkCache"
 	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
 )
 
 func main() {
 	var (
-		http, dage, engine, name string
+		http, dage, resp, engine, name, snapshot, aof, aofSync, memoryPolicy string
+		interval, aofRewrite                                                 time.Duration
+		maxMemory                                                            int64
+		inflight, slowSize                                                   int
+		slowThreshold                                                        time.Duration
+		tokens, tlsCert, tlsKey, tlsClientCA, templates, containers          string
 	)
 	flag.StringVar(&http, "http", ":1128", "Http Api Server Port")
 	flag.StringVar(&dage, "dage", ":2345", "Dage Api Server Port")
-	flag.StringVar(&engine, "engine", cache.BTreeEngine, "Store Engine, btree or hash")
+	flag.IntVar(&inflight, "dage-inflight", cache.DefaultMaxInFlight, "Commands of a Dage connection processed at once")
+	flag.StringVar(&tokens, "tokens", "", "Tokens file, a line per token as: token [ro|rw] [prefix,prefix...], empty disables auth")
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate file, serves Dage, https and RESP over TLS with -tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS private key file")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "CA file verifying client certificates, enables mutual TLS")
+	flag.DurationVar(&slowThreshold, "slowlog-threshold", cache.DefaultSlowThreshold, "Commands taking at least it are logged as slow, negative disables slow log")
+	flag.IntVar(&slowSize, "slowlog-size", cache.DefaultSlowLogSize, "Slow commands kept")
+	flag.StringVar(&resp, "resp", "", "Redis protocol Server Port, e.g. :6379, empty disables it")
+	flag.StringVar(&engine, "engine", cache.BTreeEngine, fmt.Sprintf("Store Engine, one of %s", strings.Join(cache.Engines(), ", ")))
 	flag.StringVar(&name, "name", "Default", "Server Name")
+	flag.StringVar(&containers, "containers", "", "Extra containers selected by Dage SELECT and /c/:container, as: name[:engine],name[:engine]...")
+	flag.StringVar(&templates, "templates", "", "Bulk templates file, a line per template as: pattern engine|- maxitem eliminate [eviction_policy [shards]]")
+	flag.StringVar(&snapshot, "snapshot", "", "Snapshot file, restored on startup, extra containers use it suffixed by .name")
+	flag.DurationVar(&interval, "snapshot-interval", time.Minute, "Snapshot write interval, 0 writes only on shutdown")
+	flag.StringVar(&aof, "aof", "", "Append log file, replayed on startup, extra containers use it suffixed by .name")
+	flag.StringVar(&aofSync, "aof-sync", "everysec", "Append log fsync policy, always, everysec or never")
+	flag.DurationVar(&aofRewrite, "aof-rewrite-interval", time.Hour, "Append log compaction interval, 0 disables compaction")
+	flag.Int64Var(&maxMemory, "max-memory", 0, "Max bytes of all bulks of each container, 0 means unlimited")
+	flag.StringVar(&memoryPolicy, "memory-policy", "largest", "Bulk evicted first on max memory, largest or lru")
 
 	flag.Parse()
 
-	cache.Default = cache.NewContainer(name, engine)
+	c, err := cache.NewContainer(name, engine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	//servers of c, kept in the package globals for code still using them
+	cache.Default = c
+	cache.DageApi = cache.NewDage(c)
+	cache.HttpApi = cache.NewEchoHttpServer(c)
+	cache.HttpApi.Dage = cache.DageApi
+	cache.RespApi = cache.NewRespServer(c)
+	//Dage and RESP commands share a slow log shown by SLOWLOG of both and /slowlog
+	cache.DefaultSlowLog.Configure(slowThreshold, slowSize)
+	cache.DageApi.SlowLog = cache.DefaultSlowLog
+	cache.RespApi.SlowLog = cache.DefaultSlowLog
+
+	registry := cache.NewRegistry(c)
+	all := []*cache.Container{c}
+	for _, spec := range strings.Split(containers, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		cname, cengine := spec, engine
+		if i := strings.Index(spec, ":"); i >= 0 {
+			cname, cengine = spec[:i], spec[i+1:]
+		}
+		extra, err := cache.NewContainer(cname, cengine)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("Create container %s error[%s]", cname, err.Error()))
+		}
+		if err := registry.Add(extra); err != nil {
+			log.Fatal(fmt.Sprintf("Add container %s error[%s]", cname, err.Error()))
+		}
+		all = append(all, extra)
+	}
+	cache.HttpApi.Containers = registry
+	cache.DageApi.Containers = registry
+
+	if templates != "" {
+		ts, err := cache.LoadTemplates(templates)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("Load templates %s error[%s]", templates, err.Error()))
+		}
+		for _, ct := range all {
+			if err := ct.SetTemplates(ts); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if maxMemory > 0 {
+		policy, err := cache.ParseMemoryPolicy(memoryPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, ct := range all {
+			ct.SetMaxMemory(maxMemory, policy)
+		}
+	}
+
+	if snapshot != "" {
+		for _, ct := range all {
+			path := containerFile(snapshot, c, ct)
+			if err := ct.RestoreFile(path); err != nil {
+				log.Fatal(fmt.Sprintf("Restore snapshot %s error[%s]", path, err.Error()))
+			}
+		}
+		if interval > 0 {
+			go func() {
+				for {
+					<-time.After(interval)
+					for _, ct := range all {
+						path := containerFile(snapshot, c, ct)
+						if err := ct.SnapshotFile(path); err != nil {
+							log.Error(fmt.Sprintf("Write snapshot %s error[%s]", path, err.Error()))
+						}
+					}
+				}
+			}()
+		}
+	}
+
+	if aof != "" {
+		policy, err := cache.ParseSyncPolicy(aofSync)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, ct := range all {
+			path := containerFile(aof, c, ct)
+			if err := ct.OpenAppendLog(path, policy); err != nil {
+				log.Fatal(fmt.Sprintf("Open append log %s error[%s]", path, err.Error()))
+			}
+		}
+		if aofRewrite > 0 {
+			go func() {
+				for {
+					<-time.After(aofRewrite)
+					for _, ct := range all {
+						if err := ct.RewriteAppendLog(); err != nil {
+							log.Error(fmt.Sprintf("Rewrite append log %s error[%s]", containerFile(aof, c, ct), err.Error()))
+						}
+					}
+				}
+			}()
+		}
+	}
+
+	if tokens != "" {
+		auth, err := cache.LoadTokens(tokens)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("Load tokens %s error[%s]", tokens, err.Error()))
+		}
+		cache.HttpApi.Auth = auth
+		cache.DageApi.Auth = auth
+		cache.RespApi.Auth = auth
+	}
+
+	if tlsCert != "" || tlsKey != "" {
+		cfg, err := cache.NewTLSConfig(tlsCert, tlsKey, tlsClientCA)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("Load TLS certificate %s error[%s]", tlsCert, err.Error()))
+		}
+		cache.HttpApi.TLS = cfg
+		cache.DageApi.TLS = cfg
+		cache.RespApi.TLS = cfg
+	} else if tlsClientCA != "" {
+		log.Fatal("-tls-client-ca needs -tls-cert and -tls-key")
+	}
 
 	go cache.HttpApi.Listen(http)
 
+	cache.DageApi.MaxInFlight = inflight
 	go cache.DageApi.Listen(dage)
 
-	<-(chan int)(nil)
+	if resp != "" {
+		go cache.RespApi.Listen(resp)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	for _, ct := range all {
+		if snapshot != "" {
+			path := containerFile(snapshot, c, ct)
+			if err := ct.SnapshotFile(path); err != nil {
+				log.Error(fmt.Sprintf("Write snapshot %s error[%s]", path, err.Error()))
+			}
+		}
+		if err := ct.Close(); err != nil {
+			log.Error(fmt.Sprintf("Close append log %s error[%s]", containerFile(aof, c, ct), err.Error()))
+		}
+	}
+}
+
+// containerFile is path of the default container def, other containers
+// get their name as suffix so each one keeps its own file
+func containerFile(path string, def, c *cache.Container) string {
+	if c == def {
+		return path
+	}
+	return path + "." + c.Name
 }
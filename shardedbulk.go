@@ -0,0 +1,330 @@
+package bulkCache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultShards = 16
+)
+
+type (
+	// ShardedBulk splits items to hash bulks by hash of sub key,
+	// so writes to different shards do not wait for each other.
+	// MaxItem of config bounds items of all shards by a count they share,
+	// a full shard evicts its own items first and then those of others,
+	// by oldest expire it evicts the item expiring first of all shards.
+	ShardedBulk struct {
+		Mut       *sync.RWMutex //guards config
+		shards    []*HashBulk
+		analytics *Analytics
+		config    *BulkConfig
+		count     int64  //items of all shards
+		next      uint32 //round robin shard of Evict
+		//runs eliminate pass on the wheel of container, nil runs Eliminate
+		eliminator *eliminator
+	}
+)
+
+func NewDefaultShardedBulkConfig() *BulkConfig {
+	cfg := NewDefaultHashBulkConfig()
+	cfg.Shards = DefaultShards
+	return cfg
+}
+
+func NewShardedBulk(cfg *BulkConfig) *ShardedBulk {
+	return NewShardedBulkFromCached(cfg, Cached{})
+}
+
+func NewShardedBulkFromCached(cfg *BulkConfig, cached Cached) *ShardedBulk {
+	b := newShardedBulk(cfg, cached)
+	go b.Eliminate()
+	return b
+}
+
+// newShardedBulk creates a sharded bulk without eliminate goroutine
+func newShardedBulk(cfg *BulkConfig, cached Cached) *ShardedBulk {
+	if cfg == nil {
+		cfg = NewDefaultShardedBulkConfig()
+	}
+	n := cfg.Shards
+	if n <= 0 {
+		n = DefaultShards
+	}
+	shard := shardConfig(cfg)
+	b := &ShardedBulk{
+		Mut:       &sync.RWMutex{},
+		shards:    make([]*HashBulk, n),
+		analytics: NewAnalytics(),
+		config:    cfg,
+	}
+	for i := range b.shards {
+		b.shards[i] = newHashBulk(&shard, Cached{})
+		b.shards[i].analytics = b.analytics
+		b.shards[i].count = &b.count
+	}
+	for k, v := range cached {
+		b.shard(k).put(k, v)
+		b.count++
+		b.analytics.store(k, v.Data)
+	}
+	return b
+}
+
+// shardConfig is cfg for a shard, shards reject items for oldest expire
+// so that the sharded bulk evicts the item expiring first of all shards
+func shardConfig(cfg *BulkConfig) BulkConfig {
+	shard := *cfg
+	if shard.EvictionPolicy == EvictOldestExpire {
+		shard.EvictionPolicy = EvictReject
+	}
+	return shard
+}
+
+func (b *ShardedBulk) shard(key string) *HashBulk {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return b.shards[h.Sum32()%uint32(len(b.shards))]
+}
+
+func (b *ShardedBulk) OnEvent(handler EventHandler) {
+	for _, s := range b.shards {
+		s.OnEvent(handler)
+	}
+}
+
+func (b *ShardedBulk) Config() *BulkConfig {
+	b.Mut.RLock()
+	defer b.Mut.RUnlock()
+	return b.config
+}
+
+// SetConfig changes config of every shard, shards can not change,
+// items over MaxItem are evicted from shards in turn
+func (b *ShardedBulk) SetConfig(cfg *BulkConfig) error {
+	if cfg.Shards != 0 && cfg.Shards != len(b.shards) {
+		return ErrShardsChanged
+	}
+	cp := *cfg
+	cp.Shards = len(b.shards)
+	shard := shardConfig(&cp)
+	b.Mut.Lock()
+	b.config = &cp
+	b.Mut.Unlock()
+	for _, s := range b.shards {
+		s.SetConfig(&shard)
+	}
+	policy := cp.EvictionPolicy
+	if policy == EvictReject {
+		policy = EvictOldestExpire
+	}
+	for cp.over(int(atomic.LoadInt64(&b.count))) {
+		if _, ok := b.Evict(policy); !ok {
+			break
+		}
+	}
+	return nil
+}
+
+func (b *ShardedBulk) Analytics() *Analytics {
+	return b.analytics
+}
+
+func (b *ShardedBulk) Add(key string, value []byte, expire time.Duration) error {
+	return b.AddAt(key, value, time.Now().Add(expire))
+}
+
+func (b *ShardedBulk) AddAt(key string, value []byte, expire time.Time) error {
+	s := b.shard(key)
+	err := s.AddAt(key, value, expire)
+	//the shard has nothing to evict while others fill MaxItem
+	for err == ErrBulkFull {
+		if !b.evictOther() {
+			return err
+		}
+		err = s.AddAt(key, value, expire)
+	}
+	return err
+}
+
+// evictOther evicts an item of any shard by eviction policy of config
+func (b *ShardedBulk) evictOther() bool {
+	policy := b.Config().EvictionPolicy
+	if policy == EvictReject {
+		return false
+	}
+	_, ok := b.Evict(policy)
+	return ok
+}
+
+// AddMany adds items of a shard under one lock of the shard
+func (b *ShardedBulk) AddMany(items []BatchItem) []error {
+	now := time.Now()
+	errs := make([]error, len(items))
+	groups := map[*HashBulk][]int{}
+	for i, it := range items {
+		s := b.shard(it.Key)
+		groups[s] = append(groups[s], i)
+	}
+	for s, idx := range groups {
+		batch := make([]BatchItem, len(idx))
+		for n, i := range idx {
+			batch[n] = items[i]
+		}
+		for n, err := range s.AddMany(batch) {
+			errs[idx[n]] = err
+		}
+	}
+	for n, it := range items {
+		if errs[n] == ErrBulkFull && b.evictOther() {
+			errs[n] = b.AddAt(it.Key, it.Value, it.deadline(now))
+		}
+	}
+	return errs
+}
+
+func (b *ShardedBulk) Get(key string) *Item {
+	return b.shard(key).Get(key)
+}
+
+func (b *ShardedBulk) Update(key string, value []byte, expire time.Duration) error {
+	return b.shard(key).Update(key, value, expire)
+}
+
+func (b *ShardedBulk) UpdateAt(key string, value []byte, expire time.Time) error {
+	return b.shard(key).UpdateAt(key, value, expire)
+}
+
+func (b *ShardedBulk) Delete(key string) bool {
+	return b.shard(key).Delete(key)
+}
+
+// Evict evicts from shards in turn, oldest expire evicts from the shard
+// holding the item expiring first
+func (b *ShardedBulk) Evict(policy EvictionPolicy) (int, bool) {
+	if policy == EvictOldestExpire {
+		var first *HashBulk
+		var at time.Time
+		for _, s := range b.shards {
+			if ex, ok := s.firstExpire(); ok && (first == nil || ex.Before(at)) {
+				first, at = s, ex
+			}
+		}
+		if first != nil {
+			if n, ok := first.Evict(policy); ok {
+				return n, true
+			}
+		}
+	}
+	start := atomic.AddUint32(&b.next, 1)
+	for i := range b.shards {
+		s := b.shards[(int(start)+i)%len(b.shards)]
+		if n, ok := s.Evict(policy); ok {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func (b *ShardedBulk) GetAlive() Cached {
+	cached := Cached{}
+	for _, s := range b.shards {
+		for k, v := range s.GetAlive() {
+			cached[k] = v
+		}
+	}
+	return cached
+}
+
+// Scan walks shards in order, cursor is shard index and sub key cursor of the shard
+func (b *ShardedBulk) Scan(cursor string, count int) ([]KeyItem, string) {
+	shard, sub := 0, ""
+	if len(cursor) >= 4 {
+		shard = int(binary.BigEndian.Uint32([]byte(cursor[:4])))
+		sub = cursor[4:]
+	}
+	its := []KeyItem{}
+	for ; shard < len(b.shards); shard++ {
+		page, next := b.shards[shard].Scan(sub, count-len(its))
+		its = append(its, page...)
+		if next != "" {
+			h := make([]byte, 4)
+			binary.BigEndian.PutUint32(h, uint32(shard))
+			return its, string(h) + next
+		}
+		sub = ""
+	}
+	return its, ""
+}
+
+func (b *ShardedBulk) GetAliveInBulk() Bulk {
+	return newShardedBulk(b.Config(), b.GetAlive())
+}
+
+func (b *ShardedBulk) Len() (n int) {
+	for _, s := range b.shards {
+		n += s.Len()
+	}
+	return
+}
+
+// Bytes returns bytes accounted by analytics shared by shards
+func (b *ShardedBulk) Bytes() int {
+	return int(b.analytics.Bytes())
+}
+
+func (b *ShardedBulk) String() string {
+	s := []string{"**********Sharded BULK**********"}
+	for _, shard := range b.shards {
+		for _, v := range shard.GetAlive() {
+			s = append(s, fmt.Sprintf("------[%v]@[%s]------", v.Data, v.Expire.String()))
+		}
+	}
+	return strings.Join(s, "\n")
+}
+
+// Eliminate sweeps shards one by one in a single goroutine
+func (b *ShardedBulk) Eliminate() {
+	for {
+		<-time.After(b.Config().Eliminate)
+		if !b.eliminate(time.Now()) {
+			return
+		}
+	}
+}
+
+// eliminate sweeps shards for items expired at n, it returns false once bulk is stopped
+func (b *ShardedBulk) eliminate(n time.Time) bool {
+	for _, s := range b.shards {
+		if !s.eliminate(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetScheduler runs the eliminate pass of b on w instead of its own goroutine
+func (b *ShardedBulk) SetScheduler(w *TimingWheel) {
+	e := newEliminator(w, b.eliminate, func() time.Duration { return b.Config().Eliminate })
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	b.eliminator = e
+}
+
+func (b *ShardedBulk) Stop() {
+	for _, s := range b.shards {
+		s.Stop()
+	}
+	b.Mut.Lock()
+	e := b.eliminator
+	b.Mut.Unlock()
+	if e != nil {
+		e.stop()
+	}
+}
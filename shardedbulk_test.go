@@ -0,0 +1,129 @@
+package bulkCache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ShardedBulk(t *testing.T) {
+	b := NewShardedBulk(&BulkConfig{MaxItem: 64, Eliminate: time.Millisecond * 100, Shards: 4, EvictionPolicy: EvictOldestExpire})
+	defer b.Stop()
+	wg := &sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				key := PaddingKey(fmt.Sprintf("key:%d:%d", w, i))
+				b.Add(key, []byte(fmt.Sprintf("value:%d", i)), time.Millisecond*time.Duration(100*(i+1)))
+			}
+		}(w)
+	}
+	wg.Wait()
+	if b.Len() != 40 {
+		t.Errorf("sharded bulk should have 40 items, got %d", b.Len())
+	}
+	if i := b.Get(PaddingKey("key:1:9")); i == nil || string(i.Data) != "value:9" {
+		t.Error("get item from shard failure")
+	}
+	if !b.Delete(PaddingKey("key:1:9")) || b.Get(PaddingKey("key:1:9")) != nil {
+		t.Error("delete item from shard failure")
+	}
+
+	time.Sleep(time.Millisecond * 550)
+	if n := len(b.GetAlive()); n != 19 {
+		t.Errorf("sharded bulk should keep 19 alive items, got %d", n)
+	}
+	for i := 0; i < 200; i++ {
+		if err := b.Add(PaddingKey(fmt.Sprintf("more:%d", i)), []byte("value"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Len() > 64 {
+		t.Errorf("sharded bulk exceeds max item, got %d", b.Len())
+	}
+}
+
+func Test_ShardedBulkMaxItem(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EvictReject, EvictLRU} {
+		b := NewShardedBulk(&BulkConfig{MaxItem: 5, Eliminate: time.Second, Shards: 4, EvictionPolicy: policy})
+		wg := &sync.WaitGroup{}
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 25; i++ {
+					b.Add(PaddingKey(fmt.Sprintf("key:%d:%d", w, i)), []byte("value"), time.Minute)
+				}
+			}(w)
+		}
+		wg.Wait()
+		//shards rounded up would hold 8 items
+		if b.Len() != 5 {
+			t.Errorf("[%s] sharded bulk should hold max item, got %d", policy, b.Len())
+		}
+		b.Stop()
+	}
+}
+
+func benchmarkKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = PaddingKey(fmt.Sprint(i))
+	}
+	return keys
+}
+
+func benchmarkParallelAdd(bench *testing.B, b Bulk) {
+	defer b.Stop()
+	keys := benchmarkKeys(50000)
+	value := []byte("value")
+	bench.ResetTimer()
+	bench.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			b.Add(keys[i%len(keys)], value, time.Minute)
+			i++
+		}
+	})
+}
+
+func Benchmark_HashBulkParallelAdd(bench *testing.B) {
+	benchmarkParallelAdd(bench, NewHashBulk(nil))
+}
+
+func Benchmark_ShardedBulkParallelAdd(bench *testing.B) {
+	benchmarkParallelAdd(bench, NewShardedBulk(nil))
+}
+
+func benchmarkParallelMixed(bench *testing.B, b Bulk) {
+	defer b.Stop()
+	keys := benchmarkKeys(50000)
+	value := []byte("value")
+	for _, key := range keys {
+		b.Add(key, value, time.Minute)
+	}
+	bench.ResetTimer()
+	bench.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%4 == 0 {
+				b.Add(key, value, time.Minute)
+			} else {
+				b.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func Benchmark_HashBulkParallelMixed(bench *testing.B) {
+	benchmarkParallelMixed(bench, NewHashBulk(nil))
+}
+
+func Benchmark_ShardedBulkParallelMixed(bench *testing.B) {
+	benchmarkParallelMixed(bench, NewShardedBulk(nil))
+}
@@ -0,0 +1,124 @@
+package bulkCache
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSlowLogSize = 128
+	//entries returned by SLOWLOG GET without count
+	DefaultSlowLogCount = 10
+
+	//subcommands of SLOWLOG
+	SlowGet   = "GET"
+	SlowLen   = "LEN"
+	SlowReset = "RESET"
+)
+
+var (
+	// DefaultSlowThreshold is the least duration of a slow command
+	DefaultSlowThreshold = time.Millisecond * 10
+	// DefaultSlowLog is shared by DageApi and RespApi,
+	// servers created by NewDage and NewRespServer have their own
+	DefaultSlowLog = NewSlowLog(DefaultSlowThreshold, DefaultSlowLogSize)
+)
+
+type (
+	SlowEntry struct {
+		ID       uint64
+		Time     time.Time //start of command
+		Command  string
+		Bulk     string
+		Size     int //bytes of request
+		Duration time.Duration
+		Client   string //remote address
+	}
+
+	// SlowLog keeps the latest commands taking at least threshold in a ring,
+	// a negative threshold disables it
+	SlowLog struct {
+		Mut       *sync.RWMutex
+		threshold time.Duration
+		entries   []SlowEntry
+		next      int //slot of next entry
+		count     int
+		id        uint64
+	}
+)
+
+func NewSlowLog(threshold time.Duration, size int) *SlowLog {
+	l := &SlowLog{Mut: &sync.RWMutex{}}
+	l.Configure(threshold, size)
+	return l
+}
+
+// Configure changes threshold and size, entries are kept if size is unchanged
+func (l *SlowLog) Configure(threshold time.Duration, size int) {
+	if size <= 0 {
+		size = DefaultSlowLogSize
+	}
+	l.Mut.Lock()
+	defer l.Mut.Unlock()
+	l.threshold = threshold
+	if size != len(l.entries) {
+		l.entries = make([]SlowEntry, size)
+		l.next, l.count = 0, 0
+	}
+}
+
+func (l *SlowLog) Threshold() time.Duration {
+	l.Mut.RLock()
+	defer l.Mut.RUnlock()
+	return l.threshold
+}
+
+// Slow tells whether a command taking d is logged
+func (l *SlowLog) Slow(d time.Duration) bool {
+	t := l.Threshold()
+	return t >= 0 && d >= t
+}
+
+// Record logs e if it is slow, the oldest entry is dropped once the ring is full
+func (l *SlowLog) Record(e SlowEntry) bool {
+	if !l.Slow(e.Duration) {
+		return false
+	}
+	l.Mut.Lock()
+	defer l.Mut.Unlock()
+	l.id++
+	e.ID = l.id
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.count < len(l.entries) {
+		l.count++
+	}
+	return true
+}
+
+// Entries returns at most n entries newest first, n <= 0 returns all
+func (l *SlowLog) Entries(n int) []SlowEntry {
+	l.Mut.RLock()
+	defer l.Mut.RUnlock()
+	if n <= 0 || n > l.count {
+		n = l.count
+	}
+	es := make([]SlowEntry, n)
+	for i := range es {
+		es[i] = l.entries[(l.next-1-i+len(l.entries))%len(l.entries)]
+	}
+	return es
+}
+
+func (l *SlowLog) Len() int {
+	l.Mut.RLock()
+	defer l.Mut.RUnlock()
+	return l.count
+}
+
+// Reset drops every entry, ids keep growing
+func (l *SlowLog) Reset() {
+	l.Mut.Lock()
+	defer l.Mut.Unlock()
+	l.next, l.count = 0, 0
+}
@@ -0,0 +1,75 @@
+package bulkCache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_SlowLog(t *testing.T) {
+	l := NewSlowLog(time.Millisecond, 3)
+	if l.Record(SlowEntry{Command: Set, Duration: time.Microsecond}) {
+		t.Error("fast command is logged")
+	}
+	for i := 0; i < 5; i++ {
+		l.Record(SlowEntry{Command: Set, Bulk: fmt.Sprint(i), Duration: time.Second})
+	}
+	es := l.Entries(0)
+	if l.Len() != 3 || len(es) != 3 || es[0].Bulk != "4" || es[2].Bulk != "2" || es[0].ID != 5 {
+		t.Errorf("ring keeps latest entries newest first, got %v", es)
+	}
+	if es = l.Entries(1); len(es) != 1 || es[0].Bulk != "4" {
+		t.Errorf("entries are not limited, got %v", es)
+	}
+	l.Reset()
+	if l.Len() != 0 || len(l.Entries(0)) != 0 {
+		t.Error("slow log is not reset")
+	}
+	l.Configure(-1, 3)
+	if l.Record(SlowEntry{Command: Set, Duration: time.Hour}) {
+		t.Error("disabled slow log records")
+	}
+}
+
+func Test_DageSlowLog(t *testing.T) {
+	for _, binary := range []bool{true, false} {
+		d := newTestDage()
+		d.SlowLog = NewSlowLog(0, 16)
+		c := NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		c.MaxConns = 1
+		ctx := context.Background()
+		if err := c.Set(ctx, "Slow Video", "a", []byte("Tag a"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		c.Get(ctx, "Slow Video")
+		es, err := c.SlowLog(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(es) != 2 || es[0].Command != GET || es[1].Command != Set {
+			t.Fatalf("[binary %v] slow log %v", binary, es)
+		}
+		if es[1].Bulk != "Slow Video" || es[1].Size == 0 || es[1].Client == "" || es[1].ID >= es[0].ID {
+			t.Errorf("[binary %v] slow entry %+v", binary, es[1])
+		}
+		if time.Since(es[1].Time) > time.Minute {
+			t.Errorf("[binary %v] slow entry time %s", binary, es[1].Time)
+		}
+		if binary {
+			f, err := c.Call(ctx, OpSlowLog, []byte(SlowReset))
+			if err != nil || f.Code != StatusOK {
+				t.Errorf("reset slow log %v %v", f, err)
+			}
+		} else if r, err := c.Do(ctx, SlowCmd, SlowReset); err != nil || r != Success {
+			t.Errorf("reset slow log %s %v", r, err)
+		}
+		if d.SlowLog.Len() > 1 {
+			t.Errorf("[binary %v] slow log is not reset", binary)
+		}
+		c.Close()
+		d.Listener.Close()
+	}
+}
@@ -0,0 +1,149 @@
+package bulkCache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	SnapshotVersion = 1
+)
+
+type (
+	snapshotHeader struct {
+		Version int
+		Name    string
+		Engine  string
+		Created time.Time
+		Configs map[string]*BulkConfig //set by SetBulkConfig, kept for emptied bulks
+	}
+
+	snapshotItem struct {
+		Sub    string
+		Data   []byte
+		Expire time.Time
+	}
+
+	snapshotBulk struct {
+		Key    string
+		Config *BulkConfig
+		Items  []snapshotItem
+	}
+)
+
+// Snapshot writes every alive item of the container to w
+func (c *Container) Snapshot(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	h := snapshotHeader{
+		Version: SnapshotVersion,
+		Name:    c.Name,
+		Engine:  c.Engine,
+		Created: time.Now(),
+		Configs: c.copyConfigs(),
+	}
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+
+	for k, b := range c.copyBulks() {
+		sb := snapshotBulk{Key: k, Config: b.Config()}
+		for sub, i := range b.GetAlive() {
+			sb.Items = append(sb.Items, snapshotItem{Sub: sub, Data: i.Data, Expire: i.Expire})
+		}
+		if err := enc.Encode(sb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore loads bulks written by Snapshot, expired items are skipped
+// and bulks with the same key are replaced
+func (c *Container) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	var h snapshotHeader
+	if err := dec.Decode(&h); err != nil {
+		return err
+	}
+	if h.Version != SnapshotVersion {
+		return fmt.Errorf("Unsupported snapshot version %d", h.Version)
+	}
+	c.Mut.Lock()
+	for k, cfg := range h.Configs {
+		c.configs[k] = cfg
+	}
+	c.Mut.Unlock()
+
+	bulks, items := 0, 0
+	for {
+		var sb snapshotBulk
+		err := dec.Decode(&sb)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n := time.Now()
+		cached := Cached{}
+		for _, i := range sb.Items {
+			if !n.Before(i.Expire) {
+				continue
+			}
+			cached[i.Sub] = NewItem(i.Data, i.Expire)
+		}
+		if len(cached) == 0 {
+			continue
+		}
+		c.Mut.Lock()
+		c.attach(sb.Key, c.createBulk(sb.Key, sb.Config, cached))
+		c.Mut.Unlock()
+		bulks++
+		items += len(cached)
+	}
+	c.Log.Info(fmt.Sprintf("Restore %d bulks %d items from snapshot of %s", bulks, items, h.Created.String()))
+	return nil
+}
+
+// SnapshotFile writes snapshot to a temp file and renames it to path,
+// so path always holds a complete snapshot
+func (c *Container) SnapshotFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := c.Snapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RestoreFile restores from path, a missing file is not an error
+func (c *Container) RestoreFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Restore(f)
+}
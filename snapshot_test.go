@@ -0,0 +1,73 @@
+package bulkCache
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Snapshot(t *testing.T) {
+	for _, engine := range []string{BTreeEngine, HashEngine} {
+		c, _ := NewContainer("Snapshot", engine)
+		for j := 0; j < 3; j++ {
+			for i := 0; i < 10; i++ {
+				c.Add(fmt.Sprintf("Video %d", j), fmt.Sprint(i), []byte(fmt.Sprintf("Tag %d", i)), time.Second*time.Duration(i+1))
+			}
+		}
+		buf := &bytes.Buffer{}
+		if err := c.Snapshot(buf); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second * 2)
+
+		r, _ := NewContainer("Restore", engine)
+		if err := r.Restore(buf); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 3; j++ {
+			its, ok := r.Get(fmt.Sprintf("Video %d", j))
+			if !ok || len(its) != 8 {
+				t.Errorf("[%s] bulk %d should restore 8 items, got %d", engine, j, len(its))
+			}
+		}
+		if i, ok := r.GetItem("Video 1", "9"); !ok || string(i.Data) != "Tag 9" {
+			t.Errorf("[%s] restore item failure", engine)
+		}
+
+		dir, _ := ioutil.TempDir("", "bulkd")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "bulkd.snapshot")
+		if err := r.SnapshotFile(path); err != nil {
+			t.Fatal(err)
+		}
+		f, _ := NewContainer("File", engine)
+		if err := f.RestoreFile(path); err != nil {
+			t.Fatal(err)
+		}
+		if its, _ := f.Get("Video 2"); len(its) != 8 {
+			t.Errorf("[%s] restore from file failure", engine)
+		}
+	}
+}
+
+func Test_SnapshotConfig(t *testing.T) {
+	c, _ := NewContainer("Snapshot Config", HashEngine)
+	want := BulkConfig{MaxItem: 4, Eliminate: time.Second, EvictionPolicy: EvictLRU}
+	c.SetBulkConfig("Video", &want)
+	c.removeBulk("Video")
+	buf := &bytes.Buffer{}
+	if err := c.Snapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewContainer("Restore Config", HashEngine)
+	if err := r.Restore(buf); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, ok := r.BulkConfig("Video"); !ok || *cfg != want {
+		t.Errorf("config of emptied bulk is not restored, got %+v", cfg)
+	}
+}
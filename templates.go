@@ -0,0 +1,178 @@
+package bulkCache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// BulkTemplate gives bulks whose name matches Pattern their engine and config
+	// when they are created. Pattern is matched by path.Match, a pattern ending
+	// with * and having no other wildcard matches every name with its prefix
+	BulkTemplate struct {
+		Pattern string
+		Engine  string //empty uses engine of container
+		Config  *BulkConfig
+		factory EngineFactory
+	}
+)
+
+// Match tells whether bulk name matches pattern of t
+func (t *BulkTemplate) Match(name string) bool {
+	if p := strings.TrimSuffix(t.Pattern, "*"); p != t.Pattern && !strings.ContainsAny(p, `*?[\`) {
+		return strings.HasPrefix(name, p)
+	}
+	ok, _ := path.Match(t.Pattern, name)
+	return ok
+}
+
+// validate checks pattern, engine and config of t and resolves its engine,
+// def is the engine of a template without one, empty when it is not known yet
+func (t *BulkTemplate) validate(def string) error {
+	if _, err := path.Match(t.Pattern, ""); err != nil || t.Pattern == "" {
+		return fmt.Errorf("Invalid template pattern %s", t.Pattern)
+	}
+	if t.Engine != "" {
+		f, ok := GetEngine(t.Engine)
+		if !ok {
+			return fmt.Errorf("Unknown engine %s of template %s", t.Engine, t.Pattern)
+		}
+		t.factory = f
+	}
+	if t.Config != nil {
+		if err := t.Config.Validate(); err != nil {
+			return fmt.Errorf("Template %s: %s", t.Pattern, err.Error())
+		}
+		engine := t.Engine
+		if engine == "" {
+			engine = def
+		}
+		if t.Config.Shards != 0 && engine != "" && engine != ShardedEngine {
+			return fmt.Errorf("Template %s: %s", t.Pattern, ErrShardsUnsupported.Error())
+		}
+	}
+	return nil
+}
+
+// SetTemplates replaces templates of c, the first template matching a bulk name is used
+// when the bulk is created, bulks already created keep their config
+func (c *Container) SetTemplates(ts []*BulkTemplate) error {
+	cp := make([]*BulkTemplate, len(ts))
+	for i, t := range ts {
+		tc := *t
+		if err := tc.validate(c.Engine); err != nil {
+			return err
+		}
+		cp[i] = &tc
+	}
+	c.Mut.Lock()
+	defer c.Mut.Unlock()
+	c.templates = cp
+	return nil
+}
+
+func (c *Container) Templates() []*BulkTemplate {
+	c.Mut.RLock()
+	defer c.Mut.RUnlock()
+	return append([]*BulkTemplate{}, c.templates...)
+}
+
+// template returns the first template matching key, caller must hold the lock
+func (c *Container) template(key string) *BulkTemplate {
+	for _, t := range c.templates {
+		if t.Match(key) {
+			return t
+		}
+	}
+	return nil
+}
+
+// createBulk creates bulk of key holding cached, a nil cfg takes the config set by
+// SetBulkConfig, then config of the template matching key, caller must hold the lock
+func (c *Container) createBulk(key string, cfg *BulkConfig, cached Cached) Bulk {
+	factory := c.factory
+	t := c.template(key)
+	if t != nil && t.factory != nil {
+		factory = t.factory
+	}
+	if cfg == nil {
+		cfg = c.configs[key]
+	}
+	if cfg == nil && t != nil {
+		cfg = t.Config
+	}
+	return factory(cfg, cached)
+}
+
+// engineOf returns engine name of bulk key, caller must hold the lock
+func (c *Container) engineOf(key string) string {
+	if t := c.template(key); t != nil && t.Engine != "" {
+		return t.Engine
+	}
+	return c.Engine
+}
+
+// LoadTemplates reads templates file, see ParseTemplates
+func LoadTemplates(path string) ([]*BulkTemplate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTemplates(f)
+}
+
+// ParseTemplates reads a template per line in order as
+//	pattern engine maxitem eliminate [eviction_policy [shards]]
+// engine - uses engine of container, eliminate is a duration like 500ms,
+// blank lines and lines starting with # are skipped
+func ParseTemplates(r io.Reader) ([]*BulkTemplate, error) {
+	ts := []*BulkTemplate{}
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		fs := strings.Fields(s.Text())
+		if len(fs) == 0 || strings.HasPrefix(fs[0], "#") {
+			continue
+		}
+		if len(fs) < 4 || len(fs) > 6 {
+			return nil, fmt.Errorf("Invalid template at line %d", line)
+		}
+		t := &BulkTemplate{Pattern: fs[0], Config: &BulkConfig{}}
+		if fs[1] != "-" {
+			t.Engine = fs[1]
+		}
+		var err error
+		if t.Config.MaxItem, err = strconv.Atoi(fs[2]); err != nil {
+			return nil, fmt.Errorf("Invalid maxitem %s at line %d", fs[2], line)
+		}
+		if t.Config.Eliminate, err = time.ParseDuration(fs[3]); err != nil {
+			return nil, fmt.Errorf("Invalid eliminate %s at line %d", fs[3], line)
+		}
+		if len(fs) > 4 {
+			if t.Config.EvictionPolicy, err = ParseEvictionPolicy(fs[4]); err != nil {
+				return nil, fmt.Errorf("%s at line %d", err.Error(), line)
+			}
+		}
+		if len(fs) > 5 {
+			if t.Config.Shards, err = strconv.Atoi(fs[5]); err != nil {
+				return nil, fmt.Errorf("Invalid shards %s at line %d", fs[5], line)
+			}
+		}
+		if err := t.validate(""); err != nil {
+			return nil, fmt.Errorf("%s at line %d", err.Error(), line)
+		}
+		ts = append(ts, t)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
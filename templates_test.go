@@ -0,0 +1,85 @@
+package bulkCache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseTemplates(t *testing.T) {
+	ts, err := ParseTemplates(strings.NewReader(`
+# pattern engine maxitem eliminate
+video:*      hash    100  500ms  lru
+session:??   -       -1   1s
+audio/*      sharded 64   1s     random 4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 3 || ts[0].Engine != HashEngine || ts[0].Config.MaxItem != 100 || ts[0].Config.EvictionPolicy != EvictLRU {
+		t.Fatalf("templates %+v", ts)
+	}
+	if ts[1].Engine != "" || ts[1].Config.Eliminate != time.Second || ts[2].Config.Shards != 4 {
+		t.Errorf("templates %+v %+v", ts[1], ts[2])
+	}
+	for _, bad := range []string{"video:* hash 100", "video:* nope 100 1s", "video:* hash x 1s", "[ hash 1 1s", "video:* hash 1 1s nope", "video:* btree 1 1s lru 4"} {
+		if _, err := ParseTemplates(strings.NewReader(bad)); err == nil {
+			t.Errorf("invalid template %s is parsed", bad)
+		}
+	}
+
+	matches := map[string]bool{"video:1": true, "video:a/b": true, "videos": false}
+	for name, want := range matches {
+		if ts[0].Match(name) != want {
+			t.Errorf("match %s should be %v", name, want)
+		}
+	}
+	if !ts[1].Match("session:ab") || ts[1].Match("session:abc") {
+		t.Error("glob template mismatch")
+	}
+}
+
+func Test_Templates(t *testing.T) {
+	c, _ := NewContainer("Templates", BTreeEngine)
+	err := c.SetTemplates([]*BulkTemplate{
+		{Pattern: "video:*", Engine: HashEngine, Config: &BulkConfig{MaxItem: 2, Eliminate: time.Second}},
+		{Pattern: "video:big*", Engine: HashEngine, Config: &BulkConfig{MaxItem: 100, Eliminate: time.Second}},
+		{Pattern: "session:*", Config: &BulkConfig{MaxItem: 5, Eliminate: time.Second}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetTemplates([]*BulkTemplate{{Pattern: "x", Engine: "nope"}}); err == nil {
+		t.Error("template of unknown engine is set")
+	}
+	//shards are checked against engine of container for a template without one
+	for _, bad := range []*BulkTemplate{
+		{Pattern: "x", Engine: WheelEngine, Config: &BulkConfig{MaxItem: 1, Eliminate: time.Second, Shards: 4}},
+		{Pattern: "x", Config: &BulkConfig{MaxItem: 1, Eliminate: time.Second, Shards: 4}},
+	} {
+		if err := c.SetTemplates([]*BulkTemplate{bad}); err == nil {
+			t.Errorf("template of %q engine with shards is set", bad.Engine)
+		}
+	}
+	for _, name := range []string{"video:1", "video:big", "session:1", "other"} {
+		c.Add(name, "a", []byte("value"), time.Minute)
+	}
+	b, _ := c.GetBulk("video:1")
+	if _, ok := b.(*HashBulk); !ok || b.Config().MaxItem != 2 {
+		t.Errorf("video bulk is created by %T %+v", b, b.Config())
+	}
+	//first matching template wins
+	if b, _ = c.GetBulk("video:big"); b.Config().MaxItem != 2 {
+		t.Errorf("templates are not matched in order, got %+v", b.Config())
+	}
+	b, _ = c.GetBulk("session:1")
+	if _, ok := b.(*BTreeBulk); !ok || b.Config().MaxItem != 5 {
+		t.Errorf("session bulk is created by %T %+v", b, b.Config())
+	}
+	if b, _ = c.GetBulk("other"); b.Config().MaxItem != NewDefaultBTreeBulkConfig().MaxItem {
+		t.Errorf("bulk without template gets %+v", b.Config())
+	}
+	if cfg, ok := c.BulkConfig("session:2"); !ok || cfg.MaxItem != 5 {
+		t.Errorf("config of template is not returned for empty bulk")
+	}
+}
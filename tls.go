@@ -0,0 +1,48 @@
+package bulkCache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+)
+
+var (
+	ErrClientCA = errors.New("No certificate found in client CA file")
+)
+
+// NewTLSConfig loads the certificate of a server,
+// clientCA enables mutual TLS by requiring client certificates it signed
+func NewTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrClientCA
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// listen listens tcp on port, wrapped by TLS if cfg is not nil
+func listen(port string, cfg *tls.Config) (net.Listener, error) {
+	l, err := net.Listen("tcp", port)
+	if err != nil || cfg == nil {
+		return l, err
+	}
+	return tls.NewListener(l, cfg), nil
+}
@@ -0,0 +1,136 @@
+package bulkCache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate signed by parent, nil parent makes a self-signed CA
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves certificate and key as pem files of dir
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600)
+	return certFile, keyFile
+}
+
+func (c *testCert) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func Test_DageTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bulkCache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, "Test CA", nil)
+	server := newTestCert(t, "127.0.0.1", ca)
+	client := newTestCert(t, "Test Client", ca)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := server.write(t, dir, "server")
+
+	cfg, err := NewTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTLSConfig(certFile, keyFile, keyFile); err != ErrClientCA {
+		t.Errorf("client CA without certificate error[%v]", err)
+	}
+	db, _ := NewContainer("", "")
+	d := NewDage(db)
+	d.TLS = cfg
+	d.Listen("127.0.0.1:0")
+	defer d.Listener.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for _, binary := range []bool{true, false} {
+		c := NewDageClient()
+		c.Addr = d.Listener.Addr().String()
+		c.Binary = binary
+		c.TLS = &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client.tls()}}
+		ctx := context.Background()
+		if err := c.Set(ctx, "TLS Video", "a", []byte("Tag a"), time.Minute); err != nil {
+			t.Fatalf("set over tls error[%s]", err.Error())
+		}
+		if values, err := c.Get(ctx, "TLS Video"); err != nil || len(values) != 1 || string(values[0]) != "Tag a" {
+			t.Errorf("get over tls %q error[%v]", values, err)
+		}
+		c.Close()
+	}
+
+	//server requires a client certificate
+	c := NewDageClient()
+	c.Addr = d.Listener.Addr().String()
+	c.TLS = &tls.Config{RootCAs: roots}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	if err := c.Ping(ctx); err == nil {
+		t.Error("client without certificate should fail")
+	}
+	c.Close()
+
+	//client does not trust server
+	c = NewDageClient()
+	c.Addr = d.Listener.Addr().String()
+	c.TLS = &tls.Config{Certificates: []tls.Certificate{client.tls()}}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	if err := c.Ping(ctx); err == nil {
+		t.Error("untrusted server should fail")
+	}
+	c.Close()
+}
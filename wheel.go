@@ -0,0 +1,258 @@
+package bulkCache
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWheelTick   = time.Millisecond * 100
+	DefaultWheelSize   = 64
+	DefaultWheelLevels = 4
+)
+
+type (
+	// Expirer is called back by TimingWheel when a scheduled key is due
+	Expirer interface {
+		Expire(key string)
+	}
+
+	// ScheduledBulk is a bulk expired by the TimingWheel of its container,
+	// Stop drops its timers from the wheel
+	ScheduledBulk interface {
+		Bulk
+		SetScheduler(*TimingWheel)
+	}
+
+	// TimingWheel is a hierarchical timing wheel, slot of level n spans size^n ticks.
+	// Timers of an upper level are cascaded to lower levels when their slot comes,
+	// so each tick only costs the timers actually due.
+	TimingWheel struct {
+		Mut     *sync.Mutex
+		tick    time.Duration
+		size    int64
+		levels  [][][]*wheelTimer
+		spans   []int64 //ticks of a slot pre level
+		start   time.Time
+		current int64 //ticks since start
+		timers  map[Expirer]map[string]*wheelTimer
+		stop    chan struct{}
+		stopped bool
+	}
+
+	wheelTimer struct {
+		due    int64
+		target Expirer
+		key    string
+		//place of timer in levels
+		level, slot, pos int
+	}
+
+	// eliminator runs the eliminate pass of a bulk on a timing wheel every
+	// Eliminate of the bulk, so a container needs no goroutine per bulk
+	eliminator struct {
+		wheel *TimingWheel
+		pass  func(n time.Time) bool //false once the bulk is stopped
+		every func() time.Duration
+	}
+)
+
+func NewTimingWheel(tick time.Duration, size, levels int) *TimingWheel {
+	w := &TimingWheel{
+		Mut:    &sync.Mutex{},
+		tick:   tick,
+		size:   int64(size),
+		levels: make([][][]*wheelTimer, levels),
+		spans:  make([]int64, levels),
+		start:  time.Now(),
+		timers: map[Expirer]map[string]*wheelTimer{},
+		stop:   make(chan struct{}),
+	}
+	span := int64(1)
+	for i := range w.levels {
+		w.levels[i] = make([][]*wheelTimer, size)
+		w.spans[i] = span
+		span *= w.size
+	}
+	return w
+}
+
+func NewDefaultTimingWheel() *TimingWheel {
+	return NewTimingWheel(DefaultWheelTick, DefaultWheelSize, DefaultWheelLevels)
+}
+
+// Schedule calls target.Expire(key) at the first tick not before at,
+// a timer of the same target and key scheduled before is cancelled
+func (w *TimingWheel) Schedule(at time.Time, target Expirer, key string) {
+	d := at.Sub(w.start)
+	due := int64(d / w.tick)
+	if d%w.tick != 0 {
+		due++
+	}
+	w.Mut.Lock()
+	defer w.Mut.Unlock()
+	//current slot is already fired
+	if due <= w.current {
+		due = w.current + 1
+	}
+	keys, ok := w.timers[target]
+	if !ok {
+		keys = map[string]*wheelTimer{}
+		w.timers[target] = keys
+	}
+	if old, ok := keys[key]; ok {
+		w.remove(old)
+	}
+	t := &wheelTimer{due: due, target: target, key: key}
+	keys[key] = t
+	w.add(t)
+}
+
+// Drop cancels every timer of target
+func (w *TimingWheel) Drop(target Expirer) {
+	w.Mut.Lock()
+	defer w.Mut.Unlock()
+	for _, t := range w.timers[target] {
+		w.remove(t)
+	}
+	delete(w.timers, target)
+}
+
+// remove takes t out of its slot, caller must hold the lock
+func (w *TimingWheel) remove(t *wheelTimer) {
+	ts := w.levels[t.level][t.slot]
+	last := len(ts) - 1
+	ts[t.pos] = ts[last]
+	ts[t.pos].pos = t.pos
+	ts[last] = nil
+	w.levels[t.level][t.slot] = ts[:last]
+}
+
+// forget drops t fired from timers of its target, caller must hold the lock
+func (w *TimingWheel) forget(t *wheelTimer) {
+	keys := w.timers[t.target]
+	if keys[t.key] != t {
+		return
+	}
+	delete(keys, t.key)
+	if len(keys) == 0 {
+		delete(w.timers, t.target)
+	}
+}
+
+// add puts t to the lowest level which can hold it, caller must hold the lock
+func (w *TimingWheel) add(t *wheelTimer) {
+	delta := t.due - w.current
+	if delta < 0 {
+		delta = 0
+	}
+	top := len(w.levels) - 1
+	for l := 0; l <= top; l++ {
+		if delta < w.spans[l]*w.size || l == top {
+			due := t.due
+			//beyond the top level, park in the furthest slot and cascade again later
+			if max := w.current + w.spans[l]*(w.size-1); l == top && due > max {
+				due = max
+			}
+			slot := (due / w.spans[l]) % w.size
+			t.level, t.slot, t.pos = l, int(slot), len(w.levels[l][slot])
+			w.levels[l][slot] = append(w.levels[l][slot], t)
+			return
+		}
+	}
+}
+
+// advance moves the wheel one tick and returns due timers, caller must hold the lock
+func (w *TimingWheel) advance() []*wheelTimer {
+	w.current++
+	for l := len(w.levels) - 1; l > 0; l-- {
+		if w.current%w.spans[l] != 0 {
+			continue
+		}
+		slot := (w.current / w.spans[l]) % w.size
+		ts := w.levels[l][slot]
+		w.levels[l][slot] = nil
+		for _, t := range ts {
+			w.add(t)
+		}
+	}
+	slot := w.current % w.size
+	due := []*wheelTimer{}
+	rest := []*wheelTimer{}
+	for _, t := range w.levels[0][slot] {
+		if t.due <= w.current {
+			w.forget(t)
+			due = append(due, t)
+		} else {
+			t.pos = len(rest)
+			rest = append(rest, t)
+		}
+	}
+	w.levels[0][slot] = rest
+	return due
+}
+
+// Run drives the wheel until Stop, it is the only goroutine of a wheel
+func (w *TimingWheel) Run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case n := <-ticker.C:
+			now := int64(n.Sub(w.start) / w.tick)
+			w.Mut.Lock()
+			due := []*wheelTimer{}
+			for w.current < now {
+				due = append(due, w.advance()...)
+			}
+			w.Mut.Unlock()
+			for _, t := range due {
+				t.target.Expire(t.key)
+			}
+		}
+	}
+}
+
+// Stop ends Run, it can be called more than once
+func (w *TimingWheel) Stop() {
+	w.Mut.Lock()
+	defer w.Mut.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.stop)
+	}
+}
+
+// Len returns the number of pending timers
+func (w *TimingWheel) Len() (n int) {
+	w.Mut.Lock()
+	defer w.Mut.Unlock()
+	for _, keys := range w.timers {
+		n += len(keys)
+	}
+	return
+}
+
+// newEliminator schedules the first eliminate pass on w
+func newEliminator(w *TimingWheel, pass func(time.Time) bool, every func() time.Duration) *eliminator {
+	e := &eliminator{wheel: w, pass: pass, every: every}
+	e.next()
+	return e
+}
+
+func (e *eliminator) next() {
+	e.wheel.Schedule(time.Now().Add(e.every()), e, "")
+}
+
+// Expire runs a pass and schedules the next one until the bulk stops
+func (e *eliminator) Expire(string) {
+	if e.pass(time.Now()) {
+		e.next()
+	}
+}
+
+func (e *eliminator) stop() {
+	e.wheel.Drop(e)
+}
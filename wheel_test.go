@@ -0,0 +1,167 @@
+package bulkCache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type wheelRecorder struct {
+	sync.Mutex
+	fired map[string]time.Time
+}
+
+func (r *wheelRecorder) Expire(key string) {
+	r.Lock()
+	defer r.Unlock()
+	r.fired[key] = time.Now()
+}
+
+func Test_TimingWheel(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond*10, 4, 3)
+	go w.Run()
+	defer w.Stop()
+
+	r := &wheelRecorder{fired: map[string]time.Time{}}
+	start := time.Now()
+	//spans every level and overflows the top one
+	ds := []time.Duration{0, 15, 35, 90, 170, 700, 1000}
+	for _, d := range ds {
+		w.Schedule(start.Add(time.Millisecond*d), r, fmt.Sprint(d))
+	}
+	time.Sleep(time.Millisecond * 1300)
+
+	r.Lock()
+	defer r.Unlock()
+	for _, d := range ds {
+		at, ok := r.fired[fmt.Sprint(d)]
+		if !ok {
+			t.Errorf("timer %dms is not fired", d)
+			continue
+		}
+		if at.Before(start.Add(time.Millisecond * d)) {
+			t.Errorf("timer %dms is fired early", d)
+		}
+		if at.After(start.Add(time.Millisecond*d + time.Millisecond*100)) {
+			t.Errorf("timer %dms is fired late at %s", d, at.Sub(start))
+		}
+	}
+	if w.Len() != 0 {
+		t.Errorf("wheel should be empty, %d timers left", w.Len())
+	}
+}
+
+func Test_WheelEngine(t *testing.T) {
+	c, _ := NewContainer("Wheel", WheelEngine)
+	for i := 0; i < 10; i++ {
+		c.Add("Video", fmt.Sprint(i), []byte(fmt.Sprintf("Tag %d", i)), time.Millisecond*time.Duration(100*(i+1)))
+	}
+	//overwritten with a later expire
+	c.Add("Video", "0", []byte("Tag 0"), time.Second*10)
+	time.Sleep(time.Millisecond * 760)
+	b, _ := c.GetBulk("Video")
+	if b.Len() != 5 {
+		t.Errorf("wheel bulk should keep 5 items, got %d", b.Len())
+	}
+	if _, ok := c.GetItem("Video", "0"); !ok {
+		t.Error("overwritten item is expired")
+	}
+}
+
+func Test_TimingWheelCancel(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond*10, 4, 3)
+	go w.Run()
+	defer w.Stop()
+
+	r := &wheelRecorder{fired: map[string]time.Time{}}
+	other := &wheelRecorder{fired: map[string]time.Time{}}
+	start := time.Now()
+	w.Schedule(start.Add(time.Millisecond*30), r, "moved")
+	//rescheduling cancels the first timer
+	w.Schedule(start.Add(time.Millisecond*200), r, "moved")
+	w.Schedule(start.Add(time.Millisecond*30), other, "dropped")
+	w.Schedule(start.Add(time.Millisecond*60), other, "dropped too")
+	if w.Len() != 3 {
+		t.Errorf("wheel should hold 3 timers, got %d", w.Len())
+	}
+	w.Drop(other)
+	if w.Len() != 1 {
+		t.Errorf("dropped timers are left, got %d", w.Len())
+	}
+	time.Sleep(time.Millisecond * 100)
+	r.Lock()
+	if _, ok := r.fired["moved"]; ok {
+		t.Error("rescheduled timer is fired at its first deadline")
+	}
+	r.Unlock()
+	time.Sleep(time.Millisecond * 200)
+	r.Lock()
+	if at, ok := r.fired["moved"]; !ok || at.Before(start.Add(time.Millisecond*200)) {
+		t.Error("rescheduled timer is not fired at its new deadline")
+	}
+	r.Unlock()
+	other.Lock()
+	if len(other.fired) != 0 {
+		t.Errorf("dropped timers are fired, got %v", other.fired)
+	}
+	other.Unlock()
+}
+
+func Test_ContainerWheel(t *testing.T) {
+	configs := map[string]*BulkConfig{
+		HashEngine:    NewDefaultHashBulkConfig(),
+		BTreeEngine:   NewDefaultBTreeBulkConfig(),
+		ShardedEngine: NewDefaultShardedBulkConfig(),
+		WheelEngine:   NewDefaultHashBulkConfig(),
+	}
+	for engine, cfg := range configs {
+		c, _ := NewContainer("Container Wheel "+engine, engine)
+		cfg.Eliminate = time.Millisecond * 20
+		c.AddBulk("Video", cfg)
+		c.Add("Video", "short", []byte("short"), time.Millisecond*30)
+		c.Add("Video", "long", []byte("long"), time.Minute)
+		time.Sleep(time.Millisecond * 200)
+		b, _ := c.GetBulk("Video")
+		if b.Len() != 1 {
+			t.Errorf("[%s] expired item is not eliminated by the wheel, %d items left", engine, b.Len())
+		}
+		c.Remove("Video")
+		if n := c.wheel.Len(); n != 0 {
+			t.Errorf("[%s] timers of removed bulk are left, got %d", engine, n)
+		}
+		c.Add("Video", "short", []byte("short"), time.Minute)
+		if err := c.Close(); err != nil {
+			t.Errorf("[%s] close error %s", engine, err)
+		}
+		if err := c.Close(); err != nil {
+			t.Errorf("[%s] close twice error %s", engine, err)
+		}
+	}
+}
+
+func Test_WheelBulkScheduleRace(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond, 64, 4)
+	b := NewWheelBulk(nil)
+	b.SetScheduler(w)
+	base := time.Now().Add(time.Hour)
+	for round := 0; round < 500; round++ {
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				b.AddAt("a", []byte("value"), base.Add(time.Second*time.Duration(i)))
+			}(i)
+		}
+		wg.Wait()
+		//the only timer of a is due at the expire stored last
+		i := b.Get("a")
+		w.Mut.Lock()
+		tm := w.timers[b]["a"]
+		w.Mut.Unlock()
+		if tm == nil || w.start.Add(time.Duration(tm.due)*w.tick).Before(i.Expire) {
+			t.Fatalf("round %d timer of a does not follow expire %s", round, i.Expire)
+		}
+	}
+}
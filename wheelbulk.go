@@ -0,0 +1,117 @@
+package bulkCache
+
+import (
+	"strings"
+	"time"
+)
+
+type (
+	// WheelBulk is a hash bulk expired by the TimingWheel of its container
+	// instead of scanning itself in its own goroutine
+	WheelBulk struct {
+		*HashBulk
+		wheel *TimingWheel
+	}
+)
+
+func NewWheelBulk(cfg *BulkConfig) *WheelBulk {
+	return NewWheelBulkFromCached(cfg, Cached{})
+}
+
+func NewWheelBulkFromCached(cfg *BulkConfig, cached Cached) *WheelBulk {
+	return &WheelBulk{HashBulk: newHashBulk(cfg, cached)}
+}
+
+// SetScheduler schedules current items to w and every item added later
+func (b *WheelBulk) SetScheduler(w *TimingWheel) {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	b.wheel = w
+	for k, i := range b.cache {
+		w.Schedule(i.Expire, b, k)
+	}
+}
+
+// schedule moves the timer of key to expire, a stopped bulk schedules nothing,
+// caller must hold the lock so the timer follows the expire stored last
+func (b *WheelBulk) schedule(key string, expire time.Time) {
+	if b.wheel != nil && !b.stop {
+		b.wheel.Schedule(expire, b, key)
+	}
+}
+
+// Stop drops timers of items from the wheel
+func (b *WheelBulk) Stop() {
+	b.HashBulk.Stop()
+	b.Mut.RLock()
+	w := b.wheel
+	b.Mut.RUnlock()
+	if w != nil {
+		w.Drop(b)
+	}
+}
+
+func (b *WheelBulk) Add(key string, value []byte, expire time.Duration) error {
+	return b.AddAt(key, value, time.Now().Add(expire))
+}
+
+func (b *WheelBulk) AddAt(key string, value []byte, expire time.Time) error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	if err := b.add(key, value, expire); err != nil {
+		return err
+	}
+	b.schedule(key, expire)
+	return nil
+}
+
+// AddMany adds and schedules items under one lock, errors are in order of items
+func (b *WheelBulk) AddMany(items []BatchItem) []error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	errs := make([]error, len(items))
+	now := time.Now()
+	for n, i := range items {
+		at := i.deadline(now)
+		if errs[n] = b.add(i.Key, i.Value, at); errs[n] == nil {
+			b.schedule(i.Key, at)
+		}
+	}
+	return errs
+}
+
+func (b *WheelBulk) Update(key string, value []byte, expire time.Duration) error {
+	return b.UpdateAt(key, value, time.Now().Add(expire))
+}
+
+func (b *WheelBulk) UpdateAt(key string, value []byte, expire time.Time) error {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	if err := b.update(key, value, expire); err != nil {
+		return err
+	}
+	b.schedule(key, expire)
+	return nil
+}
+
+// Expire removes the item of key if it is expired,
+// an item overwritten with a later expire is kept
+func (b *WheelBulk) Expire(key string) {
+	b.Mut.Lock()
+	defer b.Mut.Unlock()
+	i, ok := b.cache[key]
+	if !ok || !time.Now().After(i.Expire) {
+		return
+	}
+	b.analytics.Expired(key, i.Data)
+	b.drop(key)
+	b.notify(EventExpire, key, i)
+}
+
+func (b *WheelBulk) GetAliveInBulk() Bulk {
+	return NewWheelBulkFromCached(b.Config(), b.GetAlive())
+}
+
+func (b *WheelBulk) String() string {
+	return strings.Replace(b.HashBulk.String(), "Hash BULK", "Wheel BULK", 1)
+}